@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/datastrophic/kemu/pkg/cluster"
+	"github.com/spf13/cobra"
+)
+
+var autoApprove bool
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Reconcile an existing KEMU cluster with the provided configuration",
+	Long: `Compare node groups and cluster addons from the configuration with the running
+cluster, print the plan, and apply it after confirmation. Missing nodes are created,
+removed nodes are deleted, and nodes with changed labels or capacity are updated.
+Helm releases are installed, upgraded, or uninstalled accordingly.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := cmd.Flags().Parse(os.Args); err != nil {
+			return err
+		}
+		if len(clusterConfig) == 0 {
+			return fmt.Errorf("--cluster-config is required")
+		}
+
+		plan, err := cluster.PlanKemuCluster(clusterConfig, kubeconfig)
+		if err != nil {
+			return err
+		}
+		plan.Print(cmd.OutOrStdout())
+		if plan.Empty() {
+			return nil
+		}
+
+		if !autoApprove && !confirm(cmd.InOrStdin(), cmd.OutOrStdout(), "Do you want to apply these changes?") {
+			fmt.Fprintln(cmd.OutOrStdout(), "Apply cancelled.")
+			return nil
+		}
+		return cluster.ApplyKemuClusterPlan(plan, kubeconfig, cluster.WithNodeCreation(nodeCreation))
+	},
+}
+
+// confirm prompts the user and returns true only if "yes" is entered.
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "\n%s Only 'yes' will be accepted to approve.\n\nEnter a value: ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false
+	}
+	return strings.TrimSpace(answer) == "yes"
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+	applyCmd.Flags().StringVar(&clusterConfig, "cluster-config", "", "KEMU cluster configuration file or URL")
+	applyCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "kemu.config", "KUBECONFIG file for accessing the KEMU cluster")
+	applyCmd.Flags().BoolVar(&autoApprove, "auto-approve", false, "skip interactive approval of the plan")
+	addNodeCreationFlags(applyCmd)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/datastrophic/kemu/pkg/api"
 	"github.com/datastrophic/kemu/pkg/cluster"
 	"github.com/spf13/cobra"
 )
@@ -11,6 +12,16 @@ import (
 var (
 	clusterConfig string
 	kubeconfig    string
+	nodeCreation  api.NodeCreation
+	dryRun        bool
+
+	cleanupOnFailure bool
+	resume           bool
+
+	controlPlaneProvider string
+	kubeContext          string
+
+	images api.Images
 )
 
 var createClusterCmd = &cobra.Command{
@@ -23,13 +34,54 @@ var createClusterCmd = &cobra.Command{
 		if len(clusterConfig) == 0 {
 			return fmt.Errorf("--cluster-config is required")
 		}
+		if dryRun {
+			return cluster.RenderKemuCluster(clusterConfig, renderOutput, cmd.OutOrStdout())
+		}
 
-		return cluster.CreateKemuCluster(clusterConfig, clusterName, kubeconfig)
+		if cleanupOnFailure && resume {
+			return fmt.Errorf("--cleanup-on-failure and --resume are mutually exclusive")
+		}
+
+		provider, err := cluster.NewControlPlaneProvider(controlPlaneProvider, clusterName, kubeconfig, kubeContext)
+		if err != nil {
+			return err
+		}
+		defer provider.Close()
+
+		opts := []cluster.CreateOption{cluster.WithNodeCreation(nodeCreation), cluster.WithProvider(provider), cluster.WithImages(images)}
+		if cleanupOnFailure {
+			opts = append(opts, cluster.WithCleanupOnFailure())
+		}
+		if resume {
+			opts = append(opts, cluster.WithResume())
+		}
+		return cluster.CreateKemuCluster(clusterConfig, clusterName, kubeconfig, opts...)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(createClusterCmd)
 	createClusterCmd.Flags().StringVar(&clusterConfig, "cluster-config", "", "KEMU cluster configuration file or URL")
-	createClusterCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "kemu.config", "KUBECONFIG file for accessing created KEMU cluster")
+	createClusterCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "kemu.config", "KUBECONFIG file for accessing created KEMU cluster. With --provider existing, the KUBECONFIG of the cluster to install KEMU into")
+	createClusterCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the objects that would be created instead of creating the cluster")
+	createClusterCmd.Flags().BoolVar(&cleanupOnFailure, "cleanup-on-failure", false, "delete the cluster if any bootstrap phase fails")
+	createClusterCmd.Flags().BoolVar(&resume, "resume", false, "continue bootstrapping an existing cluster from the first incomplete phase")
+	createClusterCmd.Flags().StringSliceVar(&images.Preload, "preload-image", nil, "image to load into the Kind nodes from the local Docker image store (can be repeated, adds to spec.images.preload)")
+	createClusterCmd.Flags().StringSliceVar(&images.Archives, "image-archive", nil, "image tarball to load into the Kind nodes (can be repeated, adds to spec.images.archives)")
+	addRenderOutputFlag(createClusterCmd)
+	addNodeCreationFlags(createClusterCmd)
+	addProviderFlags(createClusterCmd)
+}
+
+// addProviderFlags registers flags selecting the control plane provider.
+func addProviderFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&controlPlaneProvider, "provider", cluster.ProviderKind, "control plane provider: kind creates a new Kind cluster, existing uses the cluster from --kubeconfig and --context")
+	cmd.Flags().StringVar(&kubeContext, "context", "", "kubeconfig context of the existing cluster (defaults to the current context)")
+}
+
+// addNodeCreationFlags registers flags overriding spec.nodeCreation of the cluster config.
+func addNodeCreationFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&nodeCreation.Concurrency, "node-concurrency", 0, "number of nodes created in parallel (overrides spec.nodeCreation.concurrency)")
+	cmd.Flags().Float32Var(&nodeCreation.QPS, "kube-api-qps", 0, "client-side QPS limit for node creation (overrides spec.nodeCreation.qps)")
+	cmd.Flags().IntVar(&nodeCreation.Burst, "kube-api-burst", 0, "client-side burst limit for node creation (overrides spec.nodeCreation.burst)")
 }
@@ -15,10 +15,18 @@ var deleteClusterCmd = &cobra.Command{
 			return err
 		}
 
-		return cluster.DeleteKemuCluster(clusterName)
+		provider, err := cluster.NewControlPlaneProvider(controlPlaneProvider, clusterName, kubeconfig, kubeContext)
+		if err != nil {
+			return err
+		}
+		defer provider.Close()
+
+		return cluster.DeleteKemuClusterWithProvider(clusterName, provider)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(deleteClusterCmd)
+	deleteClusterCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "kemu.config", "KUBECONFIG file of the cluster when using --provider existing")
+	addProviderFlags(deleteClusterCmd)
 }
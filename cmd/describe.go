@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/datastrophic/kemu/pkg/cluster"
+	"github.com/spf13/cobra"
+)
+
+var describeKubeconfig string
+
+var describeCmd = &cobra.Command{
+	Use:   "describe",
+	Short: "Show details of KEMU resources",
+}
+
+var describeClusterCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "Summarize node groups, capacity, addons and KWOK controller health of a KEMU cluster",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := cmd.Flags().Parse(os.Args); err != nil {
+			return err
+		}
+
+		description, err := cluster.DescribeKemuCluster(clusterName, describeKubeconfig)
+		if err != nil {
+			return err
+		}
+		return description.Print(cmd.OutOrStdout())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(describeCmd)
+	describeCmd.AddCommand(describeClusterCmd)
+	describeClusterCmd.Flags().StringVar(&describeKubeconfig, "kubeconfig", "", "KUBECONFIG file for accessing the cluster. Defaults to the kubeconfig of the Kind cluster with the provided --name")
+}
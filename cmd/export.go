@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/datastrophic/kemu/pkg/cluster"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var exportKubeconfig string
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Print a ClusterConfig recreating the current nodes and addons of a running KEMU cluster",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := cmd.Flags().Parse(os.Args); err != nil {
+			return err
+		}
+
+		config, err := cluster.ExportKemuCluster(clusterName, exportKubeconfig)
+		if err != nil {
+			return err
+		}
+		encoder := yaml.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent(2)
+		if err = encoder.Encode(config); err != nil {
+			return err
+		}
+		return encoder.Close()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringVar(&exportKubeconfig, "kubeconfig", "", "KUBECONFIG file for accessing the cluster. Defaults to the kubeconfig of the Kind cluster with the provided --name")
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/datastrophic/kemu/pkg/cluster"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var getCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Display information about KEMU clusters",
+}
+
+var getConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Print the ClusterConfig and bootstrap metadata stored in a KEMU cluster",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := cmd.Flags().Parse(os.Args); err != nil {
+			return err
+		}
+
+		record, err := cluster.GetClusterRecord(kubeconfig)
+		if err != nil {
+			return err
+		}
+		encoder := yaml.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent(2)
+		if err = encoder.Encode(record); err != nil {
+			return err
+		}
+		return encoder.Close()
+	},
+}
+
+var getClustersCmd = &cobra.Command{
+	Use:   "clusters",
+	Short: "List KEMU clusters running on this machine",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := cmd.Flags().Parse(os.Args); err != nil {
+			return err
+		}
+
+		clusters, err := cluster.ListKemuClusters()
+		if err != nil {
+			return err
+		}
+		return cluster.PrintClusters(cmd.OutOrStdout(), clusters)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(getCmd)
+	getCmd.AddCommand(getConfigCmd)
+	getCmd.AddCommand(getClustersCmd)
+	getConfigCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "kemu.config", "KUBECONFIG file for accessing the KEMU cluster")
+}
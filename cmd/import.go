@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/datastrophic/kemu/pkg/cluster"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var importOptions cluster.ImportOptions
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Create KEMU cluster configurations from existing clusters",
+}
+
+var importNodesCmd = &cobra.Command{
+	Use:   "nodes",
+	Short: "Print a ClusterConfig with node groups matching the nodes of a real cluster",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := cmd.Flags().Parse(os.Args); err != nil {
+			return err
+		}
+		if len(importOptions.From) == 0 && len(importOptions.Kubeconfig) == 0 {
+			return fmt.Errorf("either --from or --kubeconfig is required")
+		}
+		if len(importOptions.From) > 0 && len(importOptions.Kubeconfig) > 0 {
+			return fmt.Errorf("--from and --kubeconfig are mutually exclusive")
+		}
+
+		config, err := cluster.ImportNodes(importOptions)
+		if err != nil {
+			return err
+		}
+		encoder := yaml.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent(2)
+		if err = encoder.Encode(config); err != nil {
+			return err
+		}
+		return encoder.Close()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importNodesCmd)
+	importNodesCmd.Flags().StringVar(&importOptions.From, "from", "", "file with the output of kubectl get nodes -o yaml or -o json")
+	importNodesCmd.Flags().StringVar(&importOptions.Kubeconfig, "kubeconfig", "", "KUBECONFIG file for reading the nodes from a running cluster")
+	importNodesCmd.Flags().StringSliceVar(&importOptions.Labels, "labels", nil, "node label keys to keep, keys ending with * match by prefix")
+	importNodesCmd.Flags().StringSliceVar(&importOptions.Taints, "taints", nil, "node taint keys to keep, keys ending with * match by prefix")
+}
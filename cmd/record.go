@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/datastrophic/kemu/pkg/timeline"
+	"github.com/spf13/cobra"
+)
+
+var (
+	recordOptions  timeline.RecordOptions
+	recordOutput   string
+	recordDuration time.Duration
+)
+
+var recordCmd = &cobra.Command{
+	Use:   "record",
+	Short: "Record a timeline of Pod, Job, and Node transitions in a KEMU cluster until interrupted",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := cmd.Flags().Parse(os.Args); err != nil {
+			return err
+		}
+
+		var out io.Writer = cmd.OutOrStdout()
+		if len(recordOutput) > 0 {
+			file, err := os.Create(recordOutput)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			out = file
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if recordDuration > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, recordDuration)
+			defer cancel()
+		}
+		return timeline.Record(ctx, recordOptions, out)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(recordCmd)
+	recordCmd.Flags().StringVar(&recordOptions.Kubeconfig, "kubeconfig", "kemu.config", "KUBECONFIG file for accessing the KEMU cluster")
+	recordCmd.Flags().StringVar(&recordOptions.Namespace, "namespace", "", "namespace of the recorded Pods and Jobs, all namespaces when empty")
+	recordCmd.Flags().StringVarP(&recordOutput, "output", "o", "", "file to write the JSON lines timeline to, defaults to stdout")
+	recordCmd.Flags().DurationVar(&recordDuration, "duration", 0, "stop recording after the duration, e.g. 30m, records until interrupted when 0")
+}
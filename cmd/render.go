@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/datastrophic/kemu/pkg/cluster"
+	"github.com/spf13/cobra"
+)
+
+var renderOutput string
+
+var renderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Print the nodes, addon chart specs and Kind config KEMU would create without creating anything",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := cmd.Flags().Parse(os.Args); err != nil {
+			return err
+		}
+		if len(clusterConfig) == 0 {
+			return fmt.Errorf("--cluster-config is required")
+		}
+
+		return cluster.RenderKemuCluster(clusterConfig, renderOutput, cmd.OutOrStdout())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(renderCmd)
+	renderCmd.Flags().StringVar(&clusterConfig, "cluster-config", "", "KEMU cluster configuration file or URL")
+	addRenderOutputFlag(renderCmd)
+}
+
+// addRenderOutputFlag registers the output format flag of rendered objects.
+func addRenderOutputFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&renderOutput, "output", "o", cluster.RenderFormatYAML, "output format of rendered objects: yaml or json")
+}
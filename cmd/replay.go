@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/datastrophic/kemu/pkg/workload"
+	"github.com/spf13/cobra"
+)
+
+var (
+	replayOptions    workload.ReplayOptions
+	replayRecord     string
+	replayKubeconfig string
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Submit the jobs of a cluster trace to a KEMU cluster on a time-compressed schedule",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := cmd.Flags().Parse(os.Args); err != nil {
+			return err
+		}
+		if len(replayOptions.Trace) == 0 {
+			return fmt.Errorf("--trace is required")
+		}
+
+		var out io.Writer = cmd.OutOrStdout()
+		if len(replayRecord) > 0 {
+			file, err := os.Create(replayRecord)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			out = file
+		}
+		return workload.Replay(replayOptions, replayKubeconfig, out)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+	flags := replayCmd.Flags()
+	flags.StringVar(&replayKubeconfig, "kubeconfig", "kemu.config", "KUBECONFIG file for accessing the KEMU cluster")
+	flags.StringVar(&replayOptions.Trace, "trace", "", "trace file to replay")
+	flags.StringVar(&replayOptions.Format, "format", "", "trace format: csv, jsonl, or alibaba. Defaults to the format implied by the file extension")
+	flags.Float64Var(&replayOptions.Speedup, "speedup", 1, "factor compressing the submit times and durations of the trace, e.g. 60 replays an hour in a minute")
+	flags.StringVar(&replayOptions.Kind, "kind", workload.ReplayKindAuto, "kind of replayed workloads: pod, job, or auto for Pods for single-worker jobs and Jobs otherwise")
+	flags.StringVar(&replayOptions.QueueLabel, "queue-label", "", "additional label set to the queue of jobs, e.g. kueue.x-k8s.io/queue-name")
+	flags.IntVar(&replayOptions.Limit, "limit", 0, "maximum number of jobs to replay, all when 0")
+	flags.StringVar(&replayOptions.JobPrefix, "job-prefix", "", "prefix of job IDs, defaults to replay")
+	flags.StringVar(&replayOptions.Namespace, "namespace", workload.DefaultNamespace, "namespace of replayed workloads")
+	flags.BoolVar(&replayOptions.DryRun, "dry-run", false, "print the records of planned workloads without submitting them")
+	flags.StringVar(&replayRecord, "record", "", "file to write the JSON lines record of submitted workloads to, defaults to stdout")
+}
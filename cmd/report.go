@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/datastrophic/kemu/pkg/report"
+	"github.com/datastrophic/kemu/pkg/timeline"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportTimeline   string
+	reportOutputDir  string
+	reportKubeconfig string
+	reportNamespace  string
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Compute scheduling latency, allocation, fragmentation, and fairness of a recorded timeline or a running KEMU cluster",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := cmd.Flags().Parse(os.Args); err != nil {
+			return err
+		}
+
+		var events []timeline.Event
+		var end time.Time
+		var err error
+		if len(reportTimeline) > 0 {
+			events, err = timeline.Read(reportTimeline)
+		} else {
+			// The objects of a running cluster are reported until now.
+			end = time.Now().UTC()
+			events, err = timeline.Snapshot(timeline.RecordOptions{Kubeconfig: reportKubeconfig, Namespace: reportNamespace})
+		}
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return fmt.Errorf("the timeline is empty")
+		}
+
+		r := report.Compute(events, end)
+		if err := report.Write(r, reportOutputDir); err != nil {
+			return err
+		}
+		slog.Info("wrote report", "directory", reportOutputDir, "pods", r.Pods.Created, "jobs", r.Jobs.Created)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.Flags().StringVar(&reportTimeline, "timeline", "", "timeline recorded by kemu record, reports the running cluster when empty")
+	reportCmd.Flags().StringVar(&reportOutputDir, "output-dir", "report", "directory to write report.json, report.csv, and report.html to")
+	reportCmd.Flags().StringVar(&reportKubeconfig, "kubeconfig", "kemu.config", "KUBECONFIG file for accessing the KEMU cluster when no timeline is provided")
+	reportCmd.Flags().StringVar(&reportNamespace, "namespace", "", "namespace of the reported Pods and Jobs of the running cluster, all namespaces when empty")
+}
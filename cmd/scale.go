@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/datastrophic/kemu/pkg/cluster"
+	"github.com/spf13/cobra"
+)
+
+var (
+	scaleZone         string
+	scaleReplicas     int
+	scaleDrain        bool
+	scaleDrainTimeout time.Duration
+)
+
+var scaleCmd = &cobra.Command{
+	Use:   "scale",
+	Short: "Scale parts of a running KEMU cluster",
+}
+
+var scaleNodeGroupCmd = &cobra.Command{
+	Use:   "nodegroup <name>",
+	Short: "Change the number of nodes of a node group in an availability zone",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := cmd.Flags().Parse(os.Args); err != nil {
+			return err
+		}
+		if len(scaleZone) == 0 {
+			return fmt.Errorf("--zone is required")
+		}
+		if !cmd.Flags().Changed("replicas") {
+			return fmt.Errorf("--replicas is required")
+		}
+
+		return cluster.ScaleNodeGroup(args[0], scaleZone, scaleReplicas, kubeconfig, cluster.ScaleOptions{
+			Drain:        scaleDrain,
+			DrainTimeout: scaleDrainTimeout,
+			NodeCreation: nodeCreation,
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scaleCmd)
+	scaleCmd.AddCommand(scaleNodeGroupCmd)
+	scaleNodeGroupCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "kemu.config", "KUBECONFIG file for accessing the KEMU cluster")
+	scaleNodeGroupCmd.Flags().StringVar(&scaleZone, "zone", "", "availability zone of the nodes to scale")
+	scaleNodeGroupCmd.Flags().IntVar(&scaleReplicas, "replicas", 0, "desired number of nodes in the zone")
+	scaleNodeGroupCmd.Flags().BoolVar(&scaleDrain, "drain", false, "cordon nodes and evict their pods before removing them")
+	scaleNodeGroupCmd.Flags().DurationVar(&scaleDrainTimeout, "drain-timeout", cluster.DefaultDrainTimeout, "maximum time to drain a single node")
+	addNodeCreationFlags(scaleNodeGroupCmd)
+}
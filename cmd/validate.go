@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/datastrophic/kemu/pkg/cluster"
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate KEMU cluster configuration without creating a cluster",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := cmd.Flags().Parse(os.Args); err != nil {
+			return err
+		}
+		if len(clusterConfig) == 0 {
+			return fmt.Errorf("--cluster-config is required")
+		}
+
+		if err := cluster.ValidateKemuClusterConfig(clusterConfig); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "cluster config %q is valid\n", clusterConfig)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().StringVar(&clusterConfig, "cluster-config", "", "KEMU cluster configuration file or URL")
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/datastrophic/kemu/pkg/workload"
+	"github.com/spf13/cobra"
+)
+
+var (
+	submitOptions      workload.SubmitOptions
+	submitRecord       string
+	workloadKubeconfig string
+)
+
+var workloadCmd = &cobra.Command{
+	Use:   "workload",
+	Short: "Generate workloads for a KEMU cluster",
+}
+
+var workloadSubmitCmd = &cobra.Command{
+	Use:   "submit",
+	Short: "Submit workloads rendered from a template following an arrival process",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := cmd.Flags().Parse(os.Args); err != nil {
+			return err
+		}
+		if len(submitOptions.Template) == 0 {
+			return fmt.Errorf("--template is required")
+		}
+
+		var out io.Writer = cmd.OutOrStdout()
+		if len(submitRecord) > 0 {
+			file, err := os.Create(submitRecord)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			out = file
+		}
+		return workload.Submit(submitOptions, workloadKubeconfig, out)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(workloadCmd)
+	workloadCmd.AddCommand(workloadSubmitCmd)
+	flags := workloadSubmitCmd.Flags()
+	flags.StringVar(&workloadKubeconfig, "kubeconfig", "kemu.config", "KUBECONFIG file for accessing the KEMU cluster")
+	flags.StringVar(&submitOptions.Template, "template", "", "Go template of a Job, JobSet, Pod, or another workload")
+	flags.IntVar(&submitOptions.Count, "count", 1, "number of workloads to submit")
+	flags.StringVar(&submitOptions.Arrival, "arrival", "fixed:0s", "arrival process: fixed:<interval>, poisson:<count>/<period>, or burst:<count>/<period>")
+	flags.StringVar(&submitOptions.Duration, "duration", "", "distribution of Pod durations: <duration>, uniform:<min>,<max>, normal:<mean>,<stddev>, or exponential:<mean>")
+	flags.IntVar(&submitOptions.Workers, "workers", 0, "number of workers passed to the template as .Workers")
+	flags.StringVar(&submitOptions.GPUType, "gpu-type", "", "GPU type passed to the template as .GPUType")
+	flags.StringToStringVar(&submitOptions.Values, "set", nil, "values passed to the template as .Values, e.g. --set queue=research")
+	flags.StringVar(&submitOptions.JobPrefix, "job-prefix", "", "prefix of job IDs, defaults to the template file name")
+	flags.StringVar(&submitOptions.Namespace, "namespace", workload.DefaultNamespace, "namespace of workloads that don't set one")
+	flags.Uint64Var(&submitOptions.Seed, "seed", 0, "seed of random arrivals and durations for repeatable submissions, random when 0")
+	flags.BoolVar(&submitOptions.DryRun, "dry-run", false, "print the records of planned workloads without submitting them")
+	flags.StringVar(&submitRecord, "record", "", "file to write the JSON lines record of submitted workloads to, defaults to stdout")
+}
@@ -1,44 +1,264 @@
 package api
 
 import (
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	APIVersion        = "kemu.datastrophic.io/v1alpha1"
+	ClusterConfigKind = "ClusterConfig"
+
+	// EmbeddedChartPrefix marks addon charts shipped inside the KEMU binary.
+	EmbeddedChartPrefix = "embedded://"
 )
 
 type ClusterConfig struct {
-	metav1.TypeMeta `yaml:",inline"`
-	Spec            ClusterSpec `yaml:"spec"`
+	APIVersion string      `yaml:"apiVersion"`
+	Kind       string      `yaml:"kind"`
+	Spec       ClusterSpec `yaml:"spec"`
+	// Status is recorded by KEMU in the cluster and is ignored on creation.
+	Status *ClusterStatus `yaml:"status,omitempty"`
 }
 
 type ClusterSpec struct {
-	NodeGroups    []NodeGroup    `yaml:"nodeGroups"`
-	KindConfig    string         `yaml:"kindConfig"`
-	ClusterAddons []ClusterAddon `yaml:"clusterAddons"`
+	NodeGroups    []NodeGroup    `yaml:"nodeGroups,omitempty"`
+	KindConfig    string         `yaml:"kindConfig,omitempty"`
+	ClusterAddons []ClusterAddon `yaml:"clusterAddons,omitempty"`
+	NodeCreation  NodeCreation   `yaml:"nodeCreation,omitempty"`
+	Images        Images         `yaml:"images,omitempty"`
+	KWOK          KWOK           `yaml:"kwok,omitempty"`
+}
+
+// Built-in sets of KWOK stages defining the lifecycle of emulated nodes and pods.
+const (
+	// KWOKProfileFast makes nodes and pods ready immediately. Job pods
+	// complete after the delay from the pod-complete annotation.
+	KWOKProfileFast = "fast"
+	// KWOKProfileRealistic adds jittered delays to node initialization, pod
+	// startup, and pod termination.
+	KWOKProfileRealistic = "realistic-with-delays"
+	// KWOKProfileFailureProne extends the realistic profile with a share of
+	// job pods failing instead of completing.
+	KWOKProfileFailureProne = "failure-prone"
+)
+
+// KWOK configures the KWOK controller and the stages it runs.
+type KWOK struct {
+	// Version of the KWOK charts installed from the KWOK Helm repository.
+	// The charts embedded in KEMU are used by default.
+	Version string `yaml:"version,omitempty"`
+	// ValuesObject contains Helm values of the KWOK controller chart.
+	ValuesObject string `yaml:"valuesObject,omitempty"`
+	// Profile is one of the built-in stage sets. Defaults to fast.
+	Profile string `yaml:"profile,omitempty"`
+	// Stages contains KWOK Stage objects as a multi-document YAML. Stages
+	// replace the stages of the profile with the same name.
+	Stages string `yaml:"stages,omitempty"`
+}
+
+// Images are loaded into the control plane nodes before KWOK and addons are
+// installed so that the cluster can be bootstrapped without registry access.
+type Images struct {
+	// Preload lists images copied from the local Docker image store.
+	Preload []string `yaml:"preload,omitempty"`
+	// Archives lists paths to image tarballs, e.g. created with `docker save`.
+	Archives []string `yaml:"archives,omitempty"`
+}
+
+// NodeCreation controls how emulated nodes are submitted to the API server.
+// Zero values fall back to KEMU defaults.
+type NodeCreation struct {
+	// Concurrency is the number of workers creating nodes in parallel.
+	Concurrency int `yaml:"concurrency,omitempty"`
+	// QPS and Burst configure the client-side rate limiter of the API client.
+	QPS   float32 `yaml:"qps,omitempty"`
+	Burst int     `yaml:"burst,omitempty"`
 }
 
 type NodeGroup struct {
-	Name         string       `yaml:"name"`
+	Name string `yaml:"name"`
+	// InstanceType is a cloud instance type from the catalog embedded in
+	// KEMU, e.g. gcp/a3-highgpu-8g. It fills in the capacity and labels of
+	// the node template unless the template sets them.
+	InstanceType string `yaml:"instanceType,omitempty"`
+	// Replicas is the total number of nodes spread across the zones of the
+	// placement, evenly or by zone weights. Zone replicas can't be set along
+	// with it.
+	Replicas     *int         `yaml:"replicas,omitempty"`
 	Placement    []Placement  `yaml:"placement"`
 	NodeTemplate NodeTemplate `yaml:"nodeTemplate"`
 }
 
 type Placement struct {
 	AvailabilityZone string `yaml:"availabilityZone"`
-	Replicas         int    `yaml:"replicas"`
+	// Region is set as the topology.kubernetes.io/region label of the nodes.
+	Region   string `yaml:"region,omitempty"`
+	Replicas int    `yaml:"replicas"`
+	// Weight is the relative share of the node group replicas placed in the
+	// zone, e.g. a percentage.
+	Weight int `yaml:"weight,omitempty"`
+	// Topology arranges the nodes of the zone into a network topology
+	// hierarchy, e.g. blocks of racks.
+	Topology *Topology `yaml:"topology,omitempty"`
+}
+
+// Topology is a hierarchy of network topology domains within a zone. Nodes
+// fill the domains of the innermost level in order, so that replicas below
+// the capacity of the hierarchy leave the trailing domains partially filled
+// or empty. Nodes are named after the domains they belong to, e.g.
+// <group>-<zone>-block0-rack3-17.
+type Topology struct {
+	// Levels are ordered from the outermost to the innermost, e.g. block,
+	// rack, and NVLink domain.
+	Levels []TopologyLevel `yaml:"levels"`
+	// NodesPerDomain is the number of nodes in each domain of the innermost
+	// level.
+	NodesPerDomain int `yaml:"nodesPerDomain"`
+}
+
+type TopologyLevel struct {
+	// Name is used in node names and domain identifiers, e.g. rack.
+	Name string `yaml:"name"`
+	// Label is the node label holding the domain identifier, e.g.
+	// cloud.google.com/gce-topology-block. Identifiers include the zone and
+	// the outer domains, e.g. use1-block0-rack3, so they are unique within
+	// the cluster.
+	Label string `yaml:"label"`
+	// Domains is the number of domains of the level within every domain of
+	// the outer level, or within the zone for the outermost level.
+	Domains int `yaml:"domains"`
+}
+
+// Capacity returns the number of nodes the topology can hold.
+func (t Topology) Capacity() int {
+	capacity := t.NodesPerDomain
+	for _, level := range t.Levels {
+		capacity *= level.Domains
+	}
+	return capacity
 }
 
 type Resources map[string]string
 
 type NodeTemplate struct {
-	metav1.ObjectMeta `yaml:"metadata,omitempty"`
-	Capacity          Resources `yaml:"capacity"`
+	NodeMetadata `yaml:"metadata,omitempty"`
+	Capacity     Resources `yaml:"capacity"`
+	// Taints are added to the nodes along with the default KWOK taint. A taint
+	// with the key and effect of the default taint replaces it.
+	Taints []Taint `yaml:"taints,omitempty"`
+	// DisableDefaultTaint omits the kwok.x-k8s.io/node=fake:NoSchedule taint
+	// that keeps pods without a matching toleration off the emulated nodes.
+	DisableDefaultTaint bool `yaml:"disableDefaultTaint,omitempty"`
+	// Allocatable sets the allocatable resources explicitly. Resources not
+	// listed are equal to capacity. It can't be combined with reservations.
+	Allocatable Resources `yaml:"allocatable,omitempty"`
+	// SystemReserved, KubeReserved, and EvictionHard are subtracted from
+	// capacity to derive allocatable resources like the kubelet does.
+	// EvictionHard also accepts percentages of capacity, e.g. 10%.
+	SystemReserved Resources `yaml:"systemReserved,omitempty"`
+	KubeReserved   Resources `yaml:"kubeReserved,omitempty"`
+	EvictionHard   Resources `yaml:"evictionHard,omitempty"`
+	// ReservationPreset computes kube-reserved resources and eviction
+	// thresholds from capacity the way a cloud provider does. Explicit
+	// KubeReserved and EvictionHard values take precedence.
+	ReservationPreset string `yaml:"reservationPreset,omitempty"`
+	// NodeInfo sets the system information the nodes report.
+	NodeInfo NodeInfo `yaml:"nodeInfo,omitempty"`
+}
+
+// NodeInfo is the system information reported in the node status. The
+// architecture and operating system are also set as node labels.
+type NodeInfo struct {
+	// Architecture defaults to amd64.
+	Architecture string `yaml:"architecture,omitempty"`
+	// OperatingSystem defaults to linux.
+	OperatingSystem         string `yaml:"operatingSystem,omitempty"`
+	OSImage                 string `yaml:"osImage,omitempty"`
+	KernelVersion           string `yaml:"kernelVersion,omitempty"`
+	ContainerRuntimeVersion string `yaml:"containerRuntimeVersion,omitempty"`
+	// KubeletVersion defaults to the version of the control plane.
+	KubeletVersion string `yaml:"kubeletVersion,omitempty"`
+}
+
+// Reservation presets of the node template.
+const (
+	ReservationPresetGKE = "gke"
+	ReservationPresetEKS = "eks"
+)
+
+type Taint struct {
+	Key   string `yaml:"key"`
+	Value string `yaml:"value,omitempty"`
+	// Effect is one of NoSchedule, PreferNoSchedule, or NoExecute.
+	Effect string `yaml:"effect"`
+}
+
+// KWOKNodeAnnotation marks the nodes managed by KWOK. It is set by KEMU on
+// every generated node.
+const KWOKNodeAnnotation = "kwok.x-k8s.io/node"
+
+// NodeMetadata is the subset of object metadata that can be set on generated
+// nodes. Labels and annotations are merged onto every node of the group.
+type NodeMetadata struct {
+	// Name and Namespace are not supported as node names are generated and
+	// nodes are cluster-scoped. They are only parsed to report a clear error.
+	Name        string            `yaml:"name,omitempty"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
 }
 
 type ClusterAddon struct {
-	Name         string `yaml:"name"`
-	Namespace    string `yaml:"namespace"`
-	Chart        string `yaml:"chart"`
-	RepoName     string `yaml:"repoName"`
-	RepoURL      string `yaml:"repoURL"`
-	Version      string `yaml:"version"`
-	ValuesObject string `yaml:"valuesObject"`
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+	// Chart is either a chart in the Helm repository, e.g. kwok/kwok, a path
+	// to a local chart directory or .tgz archive, or an embedded chart.
+	Chart string `yaml:"chart"`
+	// RepoName and RepoURL are required for charts from Helm repositories only.
+	RepoName     string `yaml:"repoName,omitempty"`
+	RepoURL      string `yaml:"repoURL,omitempty"`
+	Version      string `yaml:"version,omitempty"`
+	ValuesObject string `yaml:"valuesObject,omitempty"`
+}
+
+// IsLocalChart reports whether the chart is installed from a local directory,
+// a .tgz archive, or the charts embedded in KEMU rather than a Helm repository.
+func (a ClusterAddon) IsLocalChart() bool {
+	return a.IsEmbeddedChart() ||
+		strings.HasSuffix(a.Chart, ".tgz") ||
+		filepath.IsAbs(a.Chart) ||
+		strings.HasPrefix(a.Chart, "./") ||
+		strings.HasPrefix(a.Chart, "../")
+}
+
+// IsEmbeddedChart reports whether the chart is shipped inside the KEMU binary.
+func (a ClusterAddon) IsEmbeddedChart() bool {
+	return strings.HasPrefix(a.Chart, EmbeddedChartPrefix)
+}
+
+// ClusterStatus describes how a running cluster was bootstrapped.
+type ClusterStatus struct {
+	ClusterName string `yaml:"clusterName"`
+	// Provider is the control plane provider the cluster was created with.
+	Provider         string        `yaml:"provider,omitempty"`
+	Kubeconfig       string        `yaml:"kubeconfig,omitempty"`
+	Context          string        `yaml:"context,omitempty"`
+	KemuVersion      string        `yaml:"kemuVersion"`
+	KWOKChartVersion string        `yaml:"kwokChartVersion"`
+	Addons           []AddonStatus `yaml:"addons,omitempty"`
+	// CompletedPhases lists the bootstrap phases finished so far, in order.
+	// An interrupted bootstrap is resumed from the first phase not listed.
+	CompletedPhases []string  `yaml:"completedPhases,omitempty"`
+	CreatedAt       time.Time `yaml:"createdAt"`
+	UpdatedAt       time.Time `yaml:"updatedAt"`
+}
+
+// AddonStatus is a Helm release installed by KEMU with its resolved chart version.
+type AddonStatus struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+	Chart     string `yaml:"chart"`
+	Version   string `yaml:"version,omitempty"`
 }
@@ -0,0 +1,121 @@
+package api
+
+import (
+	_ "embed"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Labels set on the nodes of instance types from the catalog. GPU labels
+// follow the NVIDIA GPU Feature Discovery.
+const (
+	InstanceTypeLabel = "node.kubernetes.io/instance-type"
+	GPUProductLabel   = "nvidia.com/gpu.product"
+	GPUCountLabel     = "nvidia.com/gpu.count"
+	GPUMemoryLabel    = "nvidia.com/gpu.memory"
+
+	GPUResource = "nvidia.com/gpu"
+)
+
+//go:embed instancetypes.yaml
+var instanceTypeCatalog []byte
+
+// InstanceType is a cloud instance type from the catalog embedded in KEMU.
+type InstanceType struct {
+	// Name is prefixed with the cloud provider, e.g. gcp/a3-highgpu-8g.
+	Name             string `yaml:"name"`
+	CPU              string `yaml:"cpu"`
+	Memory           string `yaml:"memory"`
+	EphemeralStorage string `yaml:"ephemeralStorage"`
+	MaxPods          int    `yaml:"maxPods"`
+	GPU              *GPU   `yaml:"gpu,omitempty"`
+	// Labels are well-known labels of the provider, e.g. the accelerator.
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+type GPU struct {
+	Count   int    `yaml:"count"`
+	Product string `yaml:"product"`
+	// Memory of a single GPU in MiB.
+	Memory int `yaml:"memory"`
+}
+
+var instanceTypes = sync.OnceValue(func() map[string]InstanceType {
+	var catalog []InstanceType
+	if err := yaml.Unmarshal(instanceTypeCatalog, &catalog); err != nil {
+		panic(fmt.Sprintf("invalid embedded instance type catalog: %v", err))
+	}
+	byName := make(map[string]InstanceType, len(catalog))
+	for _, t := range catalog {
+		byName[t.Name] = t
+	}
+	return byName
+})
+
+// LookupInstanceType returns the instance type from the catalog.
+func LookupInstanceType(name string) (InstanceType, bool) {
+	t, found := instanceTypes()[name]
+	return t, found
+}
+
+// InstanceTypeNames returns the names of all instance types in the catalog.
+func InstanceTypeNames() []string {
+	return slices.Sorted(maps.Keys(instanceTypes()))
+}
+
+// Capacity returns the node capacity of the instance type.
+func (t InstanceType) Capacity() Resources {
+	capacity := Resources{
+		"cpu":               t.CPU,
+		"memory":            t.Memory,
+		"ephemeral-storage": t.EphemeralStorage,
+		"pods":              strconv.Itoa(t.MaxPods),
+	}
+	if t.GPU != nil {
+		capacity[GPUResource] = strconv.Itoa(t.GPU.Count)
+	}
+	return capacity
+}
+
+// NodeLabels returns the labels of the instance type including the instance
+// type label without the provider prefix and the GPU labels.
+func (t InstanceType) NodeLabels() map[string]string {
+	labels := maps.Clone(t.Labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	_, name, _ := strings.Cut(t.Name, "/")
+	labels[InstanceTypeLabel] = name
+	if t.GPU != nil {
+		labels[GPUProductLabel] = t.GPU.Product
+		labels[GPUCountLabel] = strconv.Itoa(t.GPU.Count)
+		labels[GPUMemoryLabel] = strconv.Itoa(t.GPU.Memory)
+	}
+	return labels
+}
+
+// ApplyInstanceTypes fills in the capacity and labels of node groups with an
+// instance type from the catalog. Capacity and labels set in the node
+// template take precedence. Unknown instance types are reported by Validate.
+func (c *ClusterConfig) ApplyInstanceTypes() {
+	for i := range c.Spec.NodeGroups {
+		template := &c.Spec.NodeGroups[i].NodeTemplate
+		t, found := LookupInstanceType(c.Spec.NodeGroups[i].InstanceType)
+		if !found {
+			continue
+		}
+		capacity := t.Capacity()
+		maps.Copy(capacity, template.Capacity)
+		template.Capacity = capacity
+
+		labels := t.NodeLabels()
+		maps.Copy(labels, template.Labels)
+		template.Labels = labels
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+)
+
+// ZonePlacement returns the number of nodes in each zone of the node group.
+// When the node group sets the total number of replicas, it is split between
+// the zones proportionally to their weights, or evenly without weights.
+func (g NodeGroup) ZonePlacement() []Placement {
+	if g.Replicas == nil {
+		return g.Placement
+	}
+
+	weights := make([]int, len(g.Placement))
+	for i, p := range g.Placement {
+		weights[i] = p.Weight
+	}
+	if !slices.ContainsFunc(weights, func(w int) bool { return w > 0 }) {
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+
+	placement := make([]Placement, len(g.Placement))
+	for i, replicas := range distributeReplicas(*g.Replicas, weights) {
+		placement[i] = g.Placement[i]
+		placement[i].Replicas = replicas
+		placement[i].Weight = 0
+	}
+	return placement
+}
+
+// distributeReplicas splits the total proportionally to the weights using
+// the largest remainder method: every share is rounded down and the replicas
+// left over go to the shares with the largest remainders. Ties go to the
+// earlier share, so the result is deterministic.
+func distributeReplicas(total int, weights []int) []int {
+	shares := make([]int, len(weights))
+	sum := 0
+	for _, w := range weights {
+		sum += w
+	}
+	if sum == 0 {
+		return shares
+	}
+
+	remainders := make([]int, len(weights))
+	assigned := 0
+	for i, w := range weights {
+		shares[i] = total * w / sum
+		remainders[i] = total * w % sum
+		assigned += shares[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return remainders[b] - remainders[a] })
+	for _, i := range order[:total-assigned] {
+		shares[i]++
+	}
+	return shares
+}
+
+// TopologyDomain is a domain of a topology level at a node of the zone.
+type TopologyDomain struct {
+	Label string
+	// ID identifies the domain within the cluster, e.g. use1-block0-rack3.
+	ID string
+}
+
+// TopologyDomains returns the domains the i-th node of the zone belongs to,
+// from the outermost to the innermost level, and the index of the node
+// within the innermost domain. Nodes fill the innermost domains in order.
+func (p Placement) TopologyDomains(i int) ([]TopologyDomain, int) {
+	if p.Topology == nil || p.Topology.NodesPerDomain < 1 {
+		return nil, i
+	}
+	levels := p.Topology.Levels
+
+	// The index of the innermost domain is decomposed into the index of the
+	// domain at every level, like digits of a number.
+	indices := make([]int, len(levels))
+	domain := i / p.Topology.NodesPerDomain
+	for l := len(levels) - 1; l >= 0; l-- {
+		indices[l] = domain % max(levels[l].Domains, 1)
+		domain /= max(levels[l].Domains, 1)
+	}
+
+	domains := make([]TopologyDomain, len(levels))
+	id := p.AvailabilityZone
+	for l, level := range levels {
+		id = topologyDomain(id, level.Name, indices[l])
+		domains[l] = TopologyDomain{Label: level.Label, ID: id}
+	}
+	return domains, i % p.Topology.NodesPerDomain
+}
+
+func topologyDomain(outer, name string, i int) string {
+	return fmt.Sprintf("%s-%s%d", outer, name, i)
+}
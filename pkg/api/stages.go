@@ -0,0 +1,38 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+	kwok "sigs.k8s.io/kwok/pkg/apis/v1alpha1"
+)
+
+// ParseStages decodes KWOK Stage objects from a multi-document YAML. Empty
+// documents are skipped.
+func ParseStages(data string) ([]kwok.Stage, error) {
+	var stages []kwok.Stage
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(data), 4096)
+	for {
+		var stage kwok.Stage
+		err := decoder.Decode(&stage)
+		if errors.Is(err, io.EOF) {
+			return stages, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(stage.Kind) == 0 && len(stage.Name) == 0 {
+			continue
+		}
+		if stage.Kind != kwok.StageKind || stage.APIVersion != kwok.GroupVersion.String() {
+			return nil, fmt.Errorf("document %d is %s %s, expected %s %s", len(stages), stage.APIVersion, stage.Kind, kwok.GroupVersion.String(), kwok.StageKind)
+		}
+		if len(stage.Name) == 0 {
+			return nil, fmt.Errorf("stage %d has no name", len(stages))
+		}
+		stages = append(stages, stage)
+	}
+}
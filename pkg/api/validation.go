@@ -0,0 +1,547 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/api/resource"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+)
+
+const (
+	kindAPIVersion = "kind.x-k8s.io/v1alpha4"
+	kindKind       = "Cluster"
+)
+
+// Validate checks the ClusterConfig for errors that would otherwise surface
+// only after the control plane and addons are created. All problems are
+// reported at once, each prefixed with its YAML path.
+func (c *ClusterConfig) Validate() error {
+	var errs field.ErrorList
+
+	if c.APIVersion != APIVersion {
+		errs = append(errs, field.NotSupported(field.NewPath("apiVersion"), c.APIVersion, []string{APIVersion}))
+	}
+	if c.Kind != ClusterConfigKind {
+		errs = append(errs, field.NotSupported(field.NewPath("kind"), c.Kind, []string{ClusterConfigKind}))
+	}
+
+	specPath := field.NewPath("spec")
+	errs = append(errs, validateKindConfig(c.Spec.KindConfig, specPath.Child("kindConfig"))...)
+	errs = append(errs, validateNodeGroups(c.Spec.NodeGroups, specPath.Child("nodeGroups"))...)
+	errs = append(errs, validateClusterAddons(c.Spec.ClusterAddons, specPath.Child("clusterAddons"))...)
+	errs = append(errs, validateNodeCreation(c.Spec.NodeCreation, specPath.Child("nodeCreation"))...)
+	errs = append(errs, validateImages(c.Spec.Images, specPath.Child("images"))...)
+	errs = append(errs, validateKWOK(c.Spec.KWOK, specPath.Child("kwok"))...)
+
+	return joinFieldErrors(errs)
+}
+
+func validateKindConfig(kindConfig string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if len(kindConfig) == 0 {
+		return errs
+	}
+
+	var config map[string]interface{}
+	if err := yaml.Unmarshal([]byte(kindConfig), &config); err != nil {
+		return append(errs, field.Invalid(path, "", fmt.Sprintf("must be a valid Kind configuration: %v", err)))
+	}
+	required := []struct{ key, value string }{
+		{"apiVersion", kindAPIVersion},
+		{"kind", kindKind},
+	}
+	for _, f := range required {
+		value, found := config[f.key]
+		if !found {
+			errs = append(errs, field.Required(path.Child(f.key), ""))
+		} else if value != f.value {
+			errs = append(errs, field.NotSupported(path.Child(f.key), value, []string{f.value}))
+		}
+	}
+	return errs
+}
+
+func validateNodeGroups(nodeGroups []NodeGroup, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	names := make(map[string]bool)
+
+	for i, nodeGroup := range nodeGroups {
+		groupPath := path.Index(i)
+		namePath := groupPath.Child("name")
+		if len(nodeGroup.Name) == 0 {
+			errs = append(errs, field.Required(namePath, ""))
+		} else {
+			for _, msg := range validation.IsDNS1123Label(nodeGroup.Name) {
+				errs = append(errs, field.Invalid(namePath, nodeGroup.Name, msg))
+			}
+			if names[nodeGroup.Name] {
+				errs = append(errs, field.Duplicate(namePath, nodeGroup.Name))
+			}
+			names[nodeGroup.Name] = true
+		}
+
+		if len(nodeGroup.InstanceType) > 0 {
+			if _, found := LookupInstanceType(nodeGroup.InstanceType); !found {
+				errs = append(errs, field.NotSupported(groupPath.Child("instanceType"), nodeGroup.InstanceType, InstanceTypeNames()))
+			}
+		}
+		errs = append(errs, validatePlacement(nodeGroup.Placement, nodeGroup.NodeTemplate.Labels, groupPath.Child("placement"))...)
+		replicaErrs := validateReplicas(nodeGroup, groupPath)
+		errs = append(errs, replicaErrs...)
+		if len(replicaErrs) == 0 {
+			errs = append(errs, validateTopologyCapacity(nodeGroup, groupPath.Child("placement"))...)
+			errs = append(errs, validateNodeNames(nodeGroup, groupPath)...)
+		}
+		errs = append(errs, validateNodeTemplate(nodeGroup.NodeTemplate, groupPath.Child("nodeTemplate"))...)
+	}
+	return errs
+}
+
+func validatePlacement(placement []Placement, templateLabels map[string]string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	zones := make(map[string]bool)
+
+	for i, p := range placement {
+		zonePath := path.Index(i).Child("availabilityZone")
+		if len(p.AvailabilityZone) == 0 {
+			errs = append(errs, field.Required(zonePath, ""))
+		} else {
+			for _, msg := range validation.IsDNS1123Label(p.AvailabilityZone) {
+				errs = append(errs, field.Invalid(zonePath, p.AvailabilityZone, msg))
+			}
+			if zones[p.AvailabilityZone] {
+				errs = append(errs, field.Duplicate(zonePath, p.AvailabilityZone))
+			}
+			zones[p.AvailabilityZone] = true
+		}
+		if p.Replicas < 0 {
+			errs = append(errs, field.Invalid(path.Index(i).Child("replicas"), p.Replicas, "must be greater than or equal to 0"))
+		}
+		for _, msg := range validation.IsValidLabelValue(p.Region) {
+			errs = append(errs, field.Invalid(path.Index(i).Child("region"), p.Region, msg))
+		}
+		if p.Topology != nil {
+			errs = append(errs, validateTopology(*p.Topology, p.AvailabilityZone, templateLabels, path.Index(i).Child("topology"))...)
+		}
+	}
+	return errs
+}
+
+// topologyReservedLabels are set by KEMU from the placement and can't hold
+// topology domains.
+var topologyReservedLabels = []string{
+	"kubernetes.io/hostname",
+	"topology.kubernetes.io/region",
+	"topology.kubernetes.io/zone",
+}
+
+func validateTopology(topology Topology, zone string, templateLabels map[string]string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	levelsPath := path.Child("levels")
+	if len(topology.Levels) == 0 {
+		errs = append(errs, field.Required(levelsPath, ""))
+	}
+
+	names := make(map[string]bool)
+	labels := make(map[string]bool)
+	domain := zone
+	for i, level := range topology.Levels {
+		levelPath := levelsPath.Index(i)
+		if len(level.Name) == 0 {
+			errs = append(errs, field.Required(levelPath.Child("name"), ""))
+		} else {
+			for _, msg := range validation.IsDNS1123Label(level.Name) {
+				errs = append(errs, field.Invalid(levelPath.Child("name"), level.Name, msg))
+			}
+			if names[level.Name] {
+				errs = append(errs, field.Duplicate(levelPath.Child("name"), level.Name))
+			}
+			names[level.Name] = true
+		}
+
+		labelPath := levelPath.Child("label")
+		switch {
+		case len(level.Label) == 0:
+			errs = append(errs, field.Required(labelPath, ""))
+		case slices.Contains(topologyReservedLabels, level.Label):
+			errs = append(errs, field.Forbidden(labelPath, "is set by KEMU from the placement"))
+		case labels[level.Label]:
+			errs = append(errs, field.Duplicate(labelPath, level.Label))
+		default:
+			for _, msg := range validation.IsQualifiedName(level.Label) {
+				errs = append(errs, field.Invalid(labelPath, level.Label, msg))
+			}
+			if _, found := templateLabels[level.Label]; found {
+				errs = append(errs, field.Forbidden(labelPath, "is also set in the node template labels"))
+			}
+		}
+		labels[level.Label] = true
+
+		if level.Domains < 1 {
+			errs = append(errs, field.Invalid(levelPath.Child("domains"), level.Domains, "must be greater than 0"))
+			continue
+		}
+		// The identifiers of the last domain of every level are the longest.
+		domain = topologyDomain(domain, level.Name, level.Domains-1)
+		if len(level.Name) > 0 {
+			for _, msg := range validation.IsValidLabelValue(domain) {
+				errs = append(errs, field.Invalid(levelPath, domain, fmt.Sprintf("domain identifiers must be valid label values: %s", msg)))
+			}
+		}
+	}
+	if topology.NodesPerDomain < 1 {
+		errs = append(errs, field.Invalid(path.Child("nodesPerDomain"), topology.NodesPerDomain, "must be greater than 0"))
+	}
+	return errs
+}
+
+// validateTopologyCapacity checks that the zones with a topology can hold
+// their replicas.
+func validateTopologyCapacity(nodeGroup NodeGroup, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, p := range nodeGroup.ZonePlacement() {
+		if p.Topology == nil {
+			continue
+		}
+		if capacity := p.Topology.Capacity(); p.Replicas > capacity {
+			errs = append(errs, field.Invalid(path.Index(i).Child("replicas"), p.Replicas, fmt.Sprintf("must not exceed the capacity of the topology (%d nodes)", capacity)))
+		}
+	}
+	return errs
+}
+
+// validateNodeNames checks that the longest node name of every zone is a
+// valid hostname label. Node names are composed of the node group name, the
+// zone or the innermost topology domain, and the index of the node in it.
+func validateNodeNames(nodeGroup NodeGroup, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if len(nodeGroup.Name) == 0 {
+		return errs
+	}
+	for _, p := range nodeGroup.ZonePlacement() {
+		if p.Replicas < 1 || len(p.AvailabilityZone) == 0 {
+			continue
+		}
+		name := fmt.Sprintf("%s-%s-%d", nodeGroup.Name, p.AvailabilityZone, p.Replicas-1)
+		if p.Topology != nil && len(p.Topology.Levels) > 0 && p.Topology.NodesPerDomain > 0 {
+			// The identifiers of the last domain of every level are the longest.
+			domain := p.AvailabilityZone
+			for _, level := range p.Topology.Levels {
+				domain = topologyDomain(domain, level.Name, max(level.Domains, 1)-1)
+			}
+			name = fmt.Sprintf("%s-%s-%d", nodeGroup.Name, domain, min(p.Replicas, p.Topology.NodesPerDomain)-1)
+		}
+		for _, msg := range validation.IsDNS1123Label(name) {
+			errs = append(errs, field.Invalid(path, name, fmt.Sprintf("node names must be valid hostname labels: %s", msg)))
+		}
+	}
+	return errs
+}
+
+// validateReplicas checks that either the node group sets the total number
+// of replicas with optional zone weights, or every zone sets its replicas.
+func validateReplicas(nodeGroup NodeGroup, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	placementPath := path.Child("placement")
+	if nodeGroup.Replicas == nil {
+		for i, p := range nodeGroup.Placement {
+			if p.Weight != 0 {
+				errs = append(errs, field.Forbidden(placementPath.Index(i).Child("weight"), "requires replicas of the node group"))
+			}
+		}
+		return errs
+	}
+
+	if *nodeGroup.Replicas < 0 {
+		errs = append(errs, field.Invalid(path.Child("replicas"), *nodeGroup.Replicas, "must be greater than or equal to 0"))
+	}
+	if len(nodeGroup.Placement) == 0 {
+		errs = append(errs, field.Required(placementPath, "zones to spread the replicas across"))
+	}
+	weighted := 0
+	for i, p := range nodeGroup.Placement {
+		if p.Replicas != 0 {
+			errs = append(errs, field.Forbidden(placementPath.Index(i).Child("replicas"), "can't be set along with replicas of the node group"))
+		}
+		if p.Weight < 0 {
+			errs = append(errs, field.Invalid(placementPath.Index(i).Child("weight"), p.Weight, "must be greater than or equal to 0"))
+		}
+		if p.Weight != 0 {
+			weighted++
+		}
+	}
+	if weighted > 0 && weighted < len(nodeGroup.Placement) {
+		errs = append(errs, field.Invalid(placementPath, "", "weights must be set for all zones or none"))
+	}
+	return errs
+}
+
+func validateNodeTemplate(template NodeTemplate, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	metadataPath := path.Child("metadata")
+	if len(template.Name) > 0 {
+		errs = append(errs, field.Forbidden(metadataPath.Child("name"), "node names are generated from the node group name, zone, and index"))
+	}
+	if len(template.Namespace) > 0 {
+		errs = append(errs, field.Forbidden(metadataPath.Child("namespace"), "nodes are cluster-scoped"))
+	}
+	errs = append(errs, metav1validation.ValidateLabels(template.Labels, metadataPath.Child("labels"))...)
+	errs = append(errs, apivalidation.ValidateAnnotations(template.Annotations, metadataPath.Child("annotations"))...)
+	if _, found := template.Annotations[KWOKNodeAnnotation]; found {
+		errs = append(errs, field.Forbidden(metadataPath.Child("annotations").Key(KWOKNodeAnnotation), "is set by KEMU to let KWOK manage the nodes"))
+	}
+	errs = append(errs, validateResources(template.Capacity, path.Child("capacity"))...)
+	errs = append(errs, validateTaints(template.Taints, path.Child("taints"))...)
+	errs = append(errs, validateReservations(template, path)...)
+	errs = append(errs, validateNodeInfo(template.NodeInfo, path.Child("nodeInfo"))...)
+	return errs
+}
+
+func validateNodeInfo(info NodeInfo, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	architectures := []string{"amd64", "arm64", "arm", "ppc64le", "s390x"}
+	if len(info.Architecture) > 0 && !slices.Contains(architectures, info.Architecture) {
+		errs = append(errs, field.NotSupported(path.Child("architecture"), info.Architecture, architectures))
+	}
+	systems := []string{"linux", "windows"}
+	if len(info.OperatingSystem) > 0 && !slices.Contains(systems, info.OperatingSystem) {
+		errs = append(errs, field.NotSupported(path.Child("operatingSystem"), info.OperatingSystem, systems))
+	}
+	if len(info.KubeletVersion) > 0 {
+		if _, err := utilversion.ParseSemantic(info.KubeletVersion); err != nil || !strings.HasPrefix(info.KubeletVersion, "v") {
+			errs = append(errs, field.Invalid(path.Child("kubeletVersion"), info.KubeletVersion, "must be a Kubernetes version, e.g. v1.33.1"))
+		}
+	}
+	return errs
+}
+
+func validateReservations(template NodeTemplate, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	errs = append(errs, validateResources(template.Allocatable, path.Child("allocatable"))...)
+	errs = append(errs, validateResources(template.SystemReserved, path.Child("systemReserved"))...)
+	errs = append(errs, validateResources(template.KubeReserved, path.Child("kubeReserved"))...)
+	for _, name := range slices.Sorted(maps.Keys(template.EvictionHard)) {
+		value := template.EvictionHard[name]
+		if percentage, found := strings.CutSuffix(value, "%"); found {
+			if p, err := strconv.ParseFloat(percentage, 64); err != nil || p < 0 || p > 100 {
+				errs = append(errs, field.Invalid(path.Child("evictionHard").Key(name), value, "must be a percentage between 0% and 100%"))
+			}
+		} else if _, err := resource.ParseQuantity(value); err != nil {
+			errs = append(errs, field.Invalid(path.Child("evictionHard").Key(name), value, err.Error()))
+		}
+	}
+
+	presets := []string{ReservationPresetGKE, ReservationPresetEKS}
+	if len(template.ReservationPreset) > 0 && !slices.Contains(presets, template.ReservationPreset) {
+		errs = append(errs, field.NotSupported(path.Child("reservationPreset"), template.ReservationPreset, presets))
+	}
+	if len(template.Allocatable) > 0 && (len(template.SystemReserved) > 0 || len(template.KubeReserved) > 0 ||
+		len(template.EvictionHard) > 0 || len(template.ReservationPreset) > 0) {
+		errs = append(errs, field.Forbidden(path.Child("allocatable"), "can't be combined with systemReserved, kubeReserved, evictionHard, or reservationPreset"))
+	}
+	for _, name := range slices.Sorted(maps.Keys(template.Allocatable)) {
+		if _, found := template.Capacity[name]; !found {
+			errs = append(errs, field.Invalid(path.Child("allocatable").Key(name), template.Allocatable[name], "resource is not in capacity"))
+			continue
+		}
+		allocatable, err := resource.ParseQuantity(template.Allocatable[name])
+		if err != nil {
+			continue
+		}
+		if capacity, err := resource.ParseQuantity(template.Capacity[name]); err == nil && allocatable.Cmp(capacity) > 0 {
+			errs = append(errs, field.Invalid(path.Child("allocatable").Key(name), template.Allocatable[name], "must not exceed capacity"))
+		}
+	}
+	return errs
+}
+
+func validateTaints(taints []Taint, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	effects := []string{"NoSchedule", "PreferNoSchedule", "NoExecute"}
+	seen := make(map[string]bool)
+	for i, taint := range taints {
+		taintPath := path.Index(i)
+		if len(taint.Key) == 0 {
+			errs = append(errs, field.Required(taintPath.Child("key"), ""))
+		} else {
+			for _, msg := range validation.IsQualifiedName(taint.Key) {
+				errs = append(errs, field.Invalid(taintPath.Child("key"), taint.Key, msg))
+			}
+		}
+		for _, msg := range validation.IsValidLabelValue(taint.Value) {
+			errs = append(errs, field.Invalid(taintPath.Child("value"), taint.Value, msg))
+		}
+		if !slices.Contains(effects, taint.Effect) {
+			errs = append(errs, field.NotSupported(taintPath.Child("effect"), taint.Effect, effects))
+		}
+		key := taint.Key + ":" + taint.Effect
+		if seen[key] {
+			errs = append(errs, field.Duplicate(taintPath, key))
+		}
+		seen[key] = true
+	}
+	return errs
+}
+
+// standardResources are the resource names without a domain prefix that
+// nodes can report.
+var standardResources = []string{"cpu", "memory", "ephemeral-storage", "pods"}
+
+// IsStandardResourceName reports whether the resource name without a domain
+// prefix can be reported by nodes.
+func IsStandardResourceName(name string) bool {
+	return slices.Contains(standardResources, name) || strings.HasPrefix(name, "hugepages-")
+}
+
+func validateResources(resources Resources, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for _, name := range slices.Sorted(maps.Keys(resources)) {
+		quantity := resources[name]
+		if !strings.Contains(name, "/") && !IsStandardResourceName(name) {
+			errs = append(errs, field.Invalid(path.Key(name), name, fmt.Sprintf("must be one of %s, hugepages-<size>, or an extended resource with a domain prefix, e.g. nvidia.com/gpu", strings.Join(standardResources, ", "))))
+		} else {
+			for _, msg := range validation.IsQualifiedName(name) {
+				errs = append(errs, field.Invalid(path.Key(name), name, msg))
+			}
+		}
+		if _, err := resource.ParseQuantity(quantity); err != nil {
+			errs = append(errs, field.Invalid(path.Key(name), quantity, err.Error()))
+		}
+	}
+	return errs
+}
+
+func validateClusterAddons(addons []ClusterAddon, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	releases := make(map[string]bool)
+
+	for i, addon := range addons {
+		addonPath := path.Index(i)
+		required := []struct{ name, value string }{
+			{"name", addon.Name},
+			{"namespace", addon.Namespace},
+			{"chart", addon.Chart},
+		}
+		if len(addon.Chart) > 0 && !addon.IsLocalChart() {
+			required = append(required, []struct{ name, value string }{
+				{"repoName", addon.RepoName},
+				{"repoURL", addon.RepoURL},
+			}...)
+		}
+		for _, f := range required {
+			if len(f.value) == 0 {
+				errs = append(errs, field.Required(addonPath.Child(f.name), ""))
+			}
+		}
+
+		if len(addon.Namespace) > 0 {
+			for _, msg := range validation.IsDNS1123Label(addon.Namespace) {
+				errs = append(errs, field.Invalid(addonPath.Child("namespace"), addon.Namespace, msg))
+			}
+		}
+		if addon.IsLocalChart() && !addon.IsEmbeddedChart() {
+			if _, err := os.Stat(addon.Chart); err != nil {
+				errs = append(errs, field.Invalid(addonPath.Child("chart"), addon.Chart, fmt.Sprintf("local chart is not accessible: %v", err)))
+			}
+		}
+		if len(addon.RepoURL) > 0 {
+			if u, err := url.Parse(addon.RepoURL); err != nil || u.Scheme == "" || u.Host == "" {
+				errs = append(errs, field.Invalid(addonPath.Child("repoURL"), addon.RepoURL, "must be an absolute URL"))
+			}
+		}
+		if len(addon.ValuesObject) > 0 {
+			var values map[string]interface{}
+			if err := yaml.Unmarshal([]byte(addon.ValuesObject), &values); err != nil {
+				errs = append(errs, field.Invalid(addonPath.Child("valuesObject"), "", fmt.Sprintf("must be a valid YAML object: %v", err)))
+			}
+		}
+
+		release := addon.Namespace + "/" + addon.Name
+		if len(addon.Name) > 0 && releases[release] {
+			errs = append(errs, field.Duplicate(addonPath.Child("name"), addon.Name))
+		}
+		releases[release] = true
+	}
+	return errs
+}
+
+func validateNodeCreation(settings NodeCreation, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if settings.Concurrency < 0 {
+		errs = append(errs, field.Invalid(path.Child("concurrency"), settings.Concurrency, "must be greater than or equal to 0"))
+	}
+	if settings.QPS < 0 {
+		errs = append(errs, field.Invalid(path.Child("qps"), settings.QPS, "must be greater than or equal to 0"))
+	}
+	if settings.Burst < 0 {
+		errs = append(errs, field.Invalid(path.Child("burst"), settings.Burst, "must be greater than or equal to 0"))
+	}
+	return errs
+}
+
+func validateImages(images Images, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, image := range images.Preload {
+		if len(image) == 0 {
+			errs = append(errs, field.Required(path.Child("preload").Index(i), ""))
+		}
+	}
+	for i, archive := range images.Archives {
+		if _, err := os.Stat(archive); err != nil {
+			errs = append(errs, field.Invalid(path.Child("archives").Index(i), archive, fmt.Sprintf("image archive is not accessible: %v", err)))
+		}
+	}
+	return errs
+}
+
+func validateKWOK(settings KWOK, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	profiles := []string{KWOKProfileFast, KWOKProfileRealistic, KWOKProfileFailureProne}
+	if len(settings.Profile) > 0 && !slices.Contains(profiles, settings.Profile) {
+		errs = append(errs, field.NotSupported(path.Child("profile"), settings.Profile, profiles))
+	}
+	if len(settings.ValuesObject) > 0 {
+		var values map[string]interface{}
+		if err := yaml.Unmarshal([]byte(settings.ValuesObject), &values); err != nil {
+			errs = append(errs, field.Invalid(path.Child("valuesObject"), "", fmt.Sprintf("must be a valid YAML object: %v", err)))
+		}
+	}
+
+	stages, err := ParseStages(settings.Stages)
+	if err != nil {
+		return append(errs, field.Invalid(path.Child("stages"), "", fmt.Sprintf("must contain valid KWOK stages: %v", err)))
+	}
+	names := make(map[string]bool)
+	for _, stage := range stages {
+		if names[stage.Name] {
+			errs = append(errs, field.Duplicate(path.Child("stages"), stage.Name))
+		}
+		names[stage.Name] = true
+	}
+	return errs
+}
+
+// joinFieldErrors converts a field.ErrorList into a single error that
+// prints each problem on its own line.
+func joinFieldErrors(errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	joined := make([]error, 0, len(errs))
+	for _, err := range errs {
+		joined = append(joined, err)
+	}
+	return errors.Join(joined...)
+}
@@ -3,6 +3,7 @@ package cluster
 import (
 	"context"
 	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/datastrophic/kemu/pkg/api"
@@ -21,6 +22,9 @@ func InstallOrUpgradeAddons(addons []api.ClusterAddon, kubeconfig string) error
 	slog.Info("adding Helm Chart repositories")
 	repos := make(map[string]repo.Entry)
 	for _, addon := range addons {
+		if addon.IsLocalChart() {
+			continue
+		}
 		repos[addon.RepoURL] = repo.Entry{
 			Name: addon.RepoName,
 			URL:  addon.RepoURL,
@@ -43,18 +47,18 @@ func InstallOrUpgradeAddons(addons []api.ClusterAddon, kubeconfig string) error
 			return err
 		}
 
-		release := &helmclient.ChartSpec{
-			ReleaseName:     addon.Name,
-			ChartName:       addon.Chart,
-			Namespace:       addon.Namespace,
-			ValuesYaml:      addon.ValuesObject,
-			CreateNamespace: true,
-			UpgradeCRDs:     true,
-			Wait:            true,
-			Timeout:         5 * time.Minute,
+		spec := chartSpecForAddon(addon)
+		cleanup := func() {}
+		if addon.IsEmbeddedChart() {
+			var chartDir string
+			if chartDir, cleanup, err = extractEmbeddedChart(addon.Chart); err != nil {
+				return err
+			}
+			spec.ChartName = chartDir
 		}
 
-		_, err = helmClient.InstallOrUpgradeChart(context.Background(), release, &helmclient.GenericHelmOptions{})
+		_, err = helmClient.InstallOrUpgradeChart(context.Background(), spec, &helmclient.GenericHelmOptions{})
+		cleanup()
 		if err != nil {
 			return err
 		}
@@ -62,3 +66,51 @@ func InstallOrUpgradeAddons(addons []api.ClusterAddon, kubeconfig string) error
 	}
 	return err
 }
+
+// UninstallAddons removes the Helm releases of the provided addons.
+func UninstallAddons(addons []api.ClusterAddon, kubeconfig string) error {
+	for _, addon := range addons {
+		slog.Info("uninstalling addon", "name", addon.Name, "namespace", addon.Namespace)
+		helmClient, err := helmClientFromConfig(kubeconfig, addon.Namespace)
+		if err != nil {
+			return err
+		}
+		if err = helmClient.UninstallReleaseByName(addon.Name); err != nil {
+			return err
+		}
+		slog.Info("addon uninstalled", "name", addon.Name, "namespace", addon.Namespace)
+	}
+	return nil
+}
+
+// chartSpecForAddon builds the Helm release definition for the addon. Releases
+// are labeled as managed by KEMU so that they can be found later on.
+func chartSpecForAddon(addon api.ClusterAddon) *helmclient.ChartSpec {
+	return &helmclient.ChartSpec{
+		ReleaseName:     addon.Name,
+		ChartName:       addon.Chart,
+		Namespace:       addon.Namespace,
+		Version:         addon.Version,
+		ValuesYaml:      addon.ValuesObject,
+		Labels:          map[string]string{ManagedByKemuLabel: "true"},
+		CreateNamespace: true,
+		UpgradeCRDs:     true,
+		Wait:            true,
+		Timeout:         5 * time.Minute,
+	}
+}
+
+// desiredAddons returns the built-in KWOK addons followed by the addons from
+// the cluster config. Addons from the config override built-in ones with the
+// same name and namespace.
+func desiredAddons(config api.ClusterConfig) []api.ClusterAddon {
+	var addons []api.ClusterAddon
+	for _, addon := range kwokAddons(config.Spec.KWOK) {
+		if !slices.ContainsFunc(config.Spec.ClusterAddons, func(a api.ClusterAddon) bool {
+			return a.Name == addon.Name && a.Namespace == addon.Namespace
+		}) {
+			addons = append(addons, addon)
+		}
+	}
+	return append(addons, config.Spec.ClusterAddons...)
+}
@@ -0,0 +1,177 @@
+package cluster
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/datastrophic/kemu/pkg/api"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// reservationTier reserves a fraction of the resource amount up to the limit.
+type reservationTier struct {
+	limit    int64
+	fraction float64
+}
+
+const gib = 1 << 30
+
+var (
+	// cpuReservationTiers are used by both GKE and EKS, in millicores.
+	cpuReservationTiers = []reservationTier{
+		{1000, 0.06},
+		{2000, 0.01},
+		{4000, 0.005},
+		{math.MaxInt64, 0.0025},
+	}
+	// gkeMemoryReservationTiers are in bytes.
+	gkeMemoryReservationTiers = []reservationTier{
+		{4 * gib, 0.25},
+		{8 * gib, 0.2},
+		{16 * gib, 0.1},
+		{128 * gib, 0.06},
+		{math.MaxInt64, 0.02},
+	}
+
+	presetEvictionHard = api.Resources{
+		string(corev1.ResourceMemory):           "100Mi",
+		string(corev1.ResourceEphemeralStorage): "10%",
+	}
+)
+
+const (
+	// eksMaxPods is used for the EKS memory reservation when the pod
+	// capacity is not set.
+	eksMaxPods = 110
+	// eksMemoryBase and eksMemoryPerPod are reserved for the kubelet by EKS
+	// AMIs as 255Mi + 11Mi per pod.
+	eksMemoryBase   = 255 << 20
+	eksMemoryPerPod = 11 << 20
+)
+
+// nodeAllocatable returns the allocatable resources of nodes created from
+// the template. Explicit allocatable values take precedence, otherwise
+// reservations and eviction thresholds are subtracted from capacity.
+func nodeAllocatable(template api.NodeTemplate, capacity corev1.ResourceList) corev1.ResourceList {
+	allocatable := capacity.DeepCopy()
+	if len(template.Allocatable) > 0 {
+		for name, quantity := range template.Allocatable {
+			allocatable[corev1.ResourceName(name)] = resource.MustParse(quantity)
+		}
+		return allocatable
+	}
+
+	for name, reserved := range reservedResources(template, capacity) {
+		quantity, found := allocatable[name]
+		if !found {
+			continue
+		}
+		quantity.Sub(reserved)
+		if quantity.Sign() < 0 {
+			quantity = *resource.NewQuantity(0, quantity.Format)
+		}
+		allocatable[name] = quantity
+	}
+	return allocatable
+}
+
+// reservedResources sums system-reserved and kube-reserved resources with
+// hard eviction thresholds. Preset values are replaced by explicit ones.
+func reservedResources(template api.NodeTemplate, capacity corev1.ResourceList) corev1.ResourceList {
+	kubeReserved := presetKubeReserved(template.ReservationPreset, capacity)
+	for name, quantity := range template.KubeReserved {
+		kubeReserved[corev1.ResourceName(name)] = resource.MustParse(quantity)
+	}
+
+	evictionHard := make(corev1.ResourceList)
+	thresholds := make(api.Resources)
+	if len(template.ReservationPreset) > 0 {
+		for name, value := range presetEvictionHard {
+			thresholds[name] = value
+		}
+	}
+	for name, value := range template.EvictionHard {
+		thresholds[name] = value
+	}
+	for name, value := range thresholds {
+		quantity, found := evictionThreshold(value, capacity[corev1.ResourceName(name)])
+		if found {
+			evictionHard[corev1.ResourceName(name)] = quantity
+		}
+	}
+
+	reserved := make(corev1.ResourceList)
+	for _, resources := range []corev1.ResourceList{systemReserved(template), kubeReserved, evictionHard} {
+		for name, quantity := range resources {
+			total := reserved[name]
+			total.Add(quantity)
+			reserved[name] = total
+		}
+	}
+	return reserved
+}
+
+func systemReserved(template api.NodeTemplate) corev1.ResourceList {
+	reserved := make(corev1.ResourceList)
+	for name, quantity := range template.SystemReserved {
+		reserved[corev1.ResourceName(name)] = resource.MustParse(quantity)
+	}
+	return reserved
+}
+
+// evictionThreshold converts a quantity or a percentage of capacity to a
+// quantity. Percentages of resources missing from capacity are skipped.
+func evictionThreshold(value string, capacity resource.Quantity) (resource.Quantity, bool) {
+	percentage, found := strings.CutSuffix(value, "%")
+	if !found {
+		return resource.MustParse(value), true
+	}
+	if capacity.IsZero() {
+		return resource.Quantity{}, false
+	}
+	p, _ := strconv.ParseFloat(percentage, 64)
+	return *resource.NewQuantity(int64(float64(capacity.Value())*p/100), capacity.Format), true
+}
+
+// presetKubeReserved computes the CPU and memory reserved for the kubelet
+// and the container runtime by GKE and EKS.
+func presetKubeReserved(preset string, capacity corev1.ResourceList) corev1.ResourceList {
+	reserved := make(corev1.ResourceList)
+	if len(preset) == 0 {
+		return reserved
+	}
+	if cpu, found := capacity[corev1.ResourceCPU]; found {
+		reserved[corev1.ResourceCPU] = *resource.NewMilliQuantity(tieredReservation(cpu.MilliValue(), cpuReservationTiers), resource.DecimalSI)
+	}
+	memory, found := capacity[corev1.ResourceMemory]
+	if !found {
+		return reserved
+	}
+	switch preset {
+	case api.ReservationPresetGKE:
+		reserved[corev1.ResourceMemory] = *resource.NewQuantity(tieredReservation(memory.Value(), gkeMemoryReservationTiers), resource.BinarySI)
+	case api.ReservationPresetEKS:
+		maxPods := int64(eksMaxPods)
+		if pods, found := capacity[corev1.ResourcePods]; found {
+			maxPods = pods.Value()
+		}
+		reserved[corev1.ResourceMemory] = *resource.NewQuantity(eksMemoryBase+eksMemoryPerPod*maxPods, resource.BinarySI)
+	}
+	return reserved
+}
+
+// tieredReservation reserves the fraction of each tier the amount falls into.
+func tieredReservation(amount int64, tiers []reservationTier) int64 {
+	var reserved float64
+	var lower int64
+	for _, tier := range tiers {
+		if amount <= lower {
+			break
+		}
+		reserved += float64(min(amount, tier.limit)-lower) * tier.fraction
+		lower = tier.limit
+	}
+	return int64(reserved)
+}
@@ -0,0 +1,602 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/datastrophic/kemu/pkg/api"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// Plan describes the changes required to bring a running KEMU cluster to
+// the state defined in the ClusterConfig.
+type Plan struct {
+	Config api.ClusterConfig
+	// Record is the ClusterConfig previously applied to the cluster, if any.
+	Record *api.ClusterConfig
+	// Warnings are changes that can't be applied to a running cluster.
+	Warnings []string
+
+	NodesToCreate []corev1.Node
+	NodesToUpdate []NodeChange
+	NodesToDelete []corev1.Node
+
+	AddonsToInstall   []api.ClusterAddon
+	AddonsToUpgrade   []AddonChange
+	AddonsToUninstall []api.ClusterAddon
+
+	// StagesChanged is true when the KWOK profile or custom stages differ
+	// from the ones previously applied.
+	StagesChanged bool
+}
+
+// NodeChange is a desired node state along with the list of differences
+// from the live node.
+type NodeChange struct {
+	Node    corev1.Node
+	Changes []string
+}
+
+// AddonChange is a desired addon along with the list of differences from
+// the deployed Helm release.
+type AddonChange struct {
+	Addon   api.ClusterAddon
+	Changes []string
+}
+
+// Empty returns true when the cluster already matches the ClusterConfig.
+func (p *Plan) Empty() bool {
+	return len(p.NodesToCreate) == 0 && len(p.NodesToUpdate) == 0 && len(p.NodesToDelete) == 0 &&
+		len(p.AddonsToInstall) == 0 && len(p.AddonsToUpgrade) == 0 && len(p.AddonsToUninstall) == 0 &&
+		!p.StagesChanged
+}
+
+// Print writes a human-readable summary of the plan.
+func (p *Plan) Print(w io.Writer) {
+	for _, warning := range p.Warnings {
+		fmt.Fprintf(w, "Warning: %s\n", warning)
+	}
+	if p.Empty() {
+		fmt.Fprintln(w, "No changes. The cluster matches the configuration.")
+		return
+	}
+
+	fmt.Fprintln(w, "Addons:")
+	for _, addon := range p.AddonsToInstall {
+		fmt.Fprintf(w, "  + %s/%s: install %s %s\n", addon.Namespace, addon.Name, addon.Chart, addon.Version)
+	}
+	for _, change := range p.AddonsToUpgrade {
+		fmt.Fprintf(w, "  ~ %s/%s: %s\n", change.Addon.Namespace, change.Addon.Name, strings.Join(change.Changes, "; "))
+	}
+	for _, addon := range p.AddonsToUninstall {
+		fmt.Fprintf(w, "  - %s/%s: uninstall\n", addon.Namespace, addon.Name)
+	}
+
+	if p.StagesChanged {
+		fmt.Fprintf(w, "KWOK stages:\n  ~ profile %s", kwokProfile(p.Config.Spec.KWOK))
+		if len(p.Config.Spec.KWOK.Stages) > 0 {
+			fmt.Fprint(w, " with custom stages")
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "Nodes:")
+	for _, node := range p.NodesToCreate {
+		fmt.Fprintf(w, "  + %s\n", node.Name)
+	}
+	for _, change := range p.NodesToUpdate {
+		fmt.Fprintf(w, "  ~ %s: %s\n", change.Node.Name, strings.Join(change.Changes, "; "))
+	}
+	for _, node := range p.NodesToDelete {
+		fmt.Fprintf(w, "  - %s\n", node.Name)
+	}
+
+	fmt.Fprintf(w, "\nPlan: %d nodes to create, %d to update, %d to delete; %d addons to install, %d to upgrade, %d to uninstall.\n",
+		len(p.NodesToCreate), len(p.NodesToUpdate), len(p.NodesToDelete),
+		len(p.AddonsToInstall), len(p.AddonsToUpgrade), len(p.AddonsToUninstall))
+}
+
+// PlanKemuCluster compares the ClusterConfig with the live cluster and
+// returns the changes needed to reconcile them. Nothing is modified.
+func PlanKemuCluster(configPath, kubeconfig string) (*Plan, error) {
+	clusterConfig, err := parseKemuClusterConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeClient, err := kubeClientFromConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := loadClusterRecord(kubeClient)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{Config: clusterConfig, Record: record}
+	if record == nil {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("cluster config record %s/%s not found. only addons labeled as managed by KEMU will be uninstalled", ClusterRecordNamespace, ClusterRecordName))
+	} else if record.Spec.KindConfig != clusterConfig.Spec.KindConfig {
+		plan.Warnings = append(plan.Warnings, "kindConfig differs from the one used to create the cluster. control plane changes require recreating the cluster")
+	}
+
+	var applied api.KWOK
+	if record != nil {
+		applied = record.Spec.KWOK
+	}
+	plan.StagesChanged = kwokProfile(applied) != kwokProfile(clusterConfig.Spec.KWOK) || applied.Stages != clusterConfig.Spec.KWOK.Stages
+
+	if err = planNodes(plan, kubeClient); err != nil {
+		return nil, err
+	}
+	if err = planAddons(plan, kubeClient, kubeconfig); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// ApplyKemuClusterPlan executes the plan against the cluster. Addons are
+// reconciled before nodes so that schedulers and operators are in place when
+// new capacity appears.
+func ApplyKemuClusterPlan(plan *Plan, kubeconfig string, opts ...CreateOption) error {
+	slog.Info("applying KEMU cluster changes")
+	options := &createOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	settings := nodeCreationWithDefaults(mergeNodeCreation(plan.Config.Spec.NodeCreation, options.nodeCreation))
+
+	addons := slices.Clone(plan.AddonsToInstall)
+	for _, change := range plan.AddonsToUpgrade {
+		addons = append(addons, change.Addon)
+	}
+	// Stages that are no longer desired are removed before the charts are
+	// installed as the stages chart can't take over existing stages.
+	if plan.StagesChanged {
+		if err := pruneKWOKStages(plan.Config.Spec.KWOK, kubeconfig); err != nil {
+			return err
+		}
+	}
+	if len(addons) > 0 {
+		if err := InstallOrUpgradeAddons(addons, kubeconfig); err != nil {
+			return err
+		}
+	}
+	if err := UninstallAddons(plan.AddonsToUninstall, kubeconfig); err != nil {
+		return err
+	}
+	// Upgrading the KWOK stages chart reverts the pod-complete stage and the
+	// custom stages replacing chart stages.
+	if plan.StagesChanged || slices.ContainsFunc(addons, isKWOKAddon) {
+		if err := configureKWOKStages(plan.Config.Spec.KWOK, kubeconfig); err != nil {
+			return err
+		}
+	}
+
+	if len(plan.NodesToCreate) > 0 {
+		if err := createNodes(plan.NodesToCreate, settings, kubeconfig); err != nil {
+			return err
+		}
+	}
+
+	kubeClient, err := kubeClientWithRateLimits(kubeconfig, settings.QPS, settings.Burst)
+	if err != nil {
+		return err
+	}
+	if len(plan.NodesToUpdate) > 0 {
+		nodes := make([]corev1.Node, 0, len(plan.NodesToUpdate))
+		for _, change := range plan.NodesToUpdate {
+			nodes = append(nodes, change.Node)
+		}
+		err = forEachNode(nodes, settings.Concurrency, "update", func(node *corev1.Node) error {
+			return updateNode(kubeClient, node)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(plan.NodesToDelete) > 0 {
+		err = forEachNode(plan.NodesToDelete, settings.Concurrency, "delete", func(node *corev1.Node) error {
+			return deleteNode(kubeClient, node.Name)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = updateClusterRecord(kubeClient, plan.Config, plan.Record, kubeconfig); err != nil {
+		return err
+	}
+
+	slog.Info("KEMU cluster changes applied")
+	return nil
+}
+
+// updateClusterRecord stores the applied config, keeping the cluster
+// identity from the previous record.
+func updateClusterRecord(kubeClient kubernetes.Interface, config api.ClusterConfig, record *api.ClusterConfig, kubeconfig string) error {
+	name := ""
+	if record != nil && record.Status != nil {
+		name = record.Status.ClusterName
+	}
+	status, err := clusterStatus(kubeClient, config, name, kubeconfig)
+	if err != nil {
+		return err
+	}
+	return saveClusterRecord(kubeClient, config, status)
+}
+
+func planNodes(plan *Plan, kubeClient kubernetes.Interface) error {
+	live, err := listKemuNodes(kubeClient)
+	if err != nil {
+		return err
+	}
+
+	liveByName := make(map[string]corev1.Node, len(live))
+	for _, node := range live {
+		liveByName[node.Name] = node
+	}
+
+	version, err := controlPlaneVersion(kubeClient)
+	if err != nil {
+		return err
+	}
+
+	desiredNames := make(map[string]bool)
+	for _, nodeGroup := range withKubeletVersion(plan.Config.Spec.NodeGroups, version) {
+		for _, placement := range nodeGroup.ZonePlacement() {
+			for _, node := range createNodeSpecs(nodeGroup, placement) {
+				desiredNames[node.Name] = true
+				liveNode, found := liveByName[node.Name]
+				if !found {
+					plan.NodesToCreate = append(plan.NodesToCreate, node)
+					continue
+				}
+				if changes := nodeChanges(node, liveNode); len(changes) > 0 {
+					plan.NodesToUpdate = append(plan.NodesToUpdate, NodeChange{Node: node, Changes: changes})
+				}
+			}
+		}
+	}
+
+	for _, node := range live {
+		if !desiredNames[node.Name] {
+			plan.NodesToDelete = append(plan.NodesToDelete, node)
+		}
+	}
+	return nil
+}
+
+// nodeChanges lists the differences between the desired and the live node
+// that KEMU manages: labels and resources.
+func nodeChanges(desired, live corev1.Node) []string {
+	var changes []string
+	if keys := changedKeys(managedLabels(desired), managedLabels(live), func(a, b string) bool { return a == b }); len(keys) > 0 {
+		changes = append(changes, fmt.Sprintf("labels: %s", strings.Join(keys, ", ")))
+	}
+	if keys := changedKeys(managedAnnotations(desired), managedAnnotations(live), func(a, b string) bool { return a == b }); len(keys) > 0 {
+		changes = append(changes, fmt.Sprintf("annotations: %s", strings.Join(keys, ", ")))
+	}
+	equalQuantity := func(a, b resource.Quantity) bool { return a.Cmp(b) == 0 }
+	if keys := changedKeys(desired.Status.Capacity, live.Status.Capacity, equalQuantity); len(keys) > 0 {
+		changes = append(changes, fmt.Sprintf("capacity: %s", strings.Join(keys, ", ")))
+	}
+	if keys := changedKeys(desired.Status.Allocatable, live.Status.Allocatable, equalQuantity); len(keys) > 0 {
+		changes = append(changes, fmt.Sprintf("allocatable: %s", strings.Join(keys, ", ")))
+	}
+	if fields := changedNodeInfo(desired.Status.NodeInfo, live.Status.NodeInfo); len(fields) > 0 {
+		changes = append(changes, fmt.Sprintf("nodeInfo: %s", strings.Join(fields, ", ")))
+	}
+	if keys := changedKeys(taintsByKey(desired.Spec.Taints), taintsByKey(live.Spec.Taints), func(a, b string) bool { return a == b }); len(keys) > 0 {
+		changes = append(changes, fmt.Sprintf("taints: %s", strings.Join(keys, ", ")))
+	}
+	return changes
+}
+
+// nodeInfoFields returns the node info fields set from the node template.
+func nodeInfoFields(info *corev1.NodeSystemInfo) map[string]*string {
+	return map[string]*string{
+		"architecture":            &info.Architecture,
+		"operatingSystem":         &info.OperatingSystem,
+		"osImage":                 &info.OSImage,
+		"kernelVersion":           &info.KernelVersion,
+		"containerRuntimeVersion": &info.ContainerRuntimeVersion,
+		"kubeletVersion":          &info.KubeletVersion,
+	}
+}
+
+// changedNodeInfo returns the sorted node info fields that differ. Fields
+// not set in the desired node info are filled in by KWOK and are ignored.
+func changedNodeInfo(desired, live corev1.NodeSystemInfo) []string {
+	var fields []string
+	liveFields := nodeInfoFields(&live)
+	for name, value := range nodeInfoFields(&desired) {
+		if len(*value) > 0 && *value != *liveFields[name] {
+			fields = append(fields, name)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// managedAnnotations returns the node annotations set by KEMU: the KWOK
+// annotation and the annotations from the node template.
+func managedAnnotations(node corev1.Node) map[string]string {
+	out := make(map[string]string)
+	for _, k := range append(templateAnnotationKeys(node), api.KWOKNodeAnnotation, templateAnnotationsKey, templateLabelsKey) {
+		if v, found := node.Annotations[k]; found {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// managedLabels returns the node labels set by KEMU: the labels generated for
+// every node and the labels from the node template and the topology.
+func managedLabels(node corev1.Node) map[string]string {
+	out := make(map[string]string)
+	for _, k := range append(templateLabelKeys(node), generatedNodeLabels...) {
+		if v, found := node.Labels[k]; found {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// taintsByKey maps key:effect of each taint to its value. Taints managed by
+// the node lifecycle controller are skipped.
+func taintsByKey(taints []corev1.Taint) map[string]string {
+	out := make(map[string]string, len(taints))
+	for _, taint := range taints {
+		if !isSystemTaint(taint) {
+			out[taint.Key+":"+string(taint.Effect)] = taint.Value
+		}
+	}
+	return out
+}
+
+func isSystemTaint(taint corev1.Taint) bool {
+	return strings.HasPrefix(taint.Key, "node.kubernetes.io/") || strings.HasPrefix(taint.Key, "node.cloudprovider.kubernetes.io/")
+}
+
+// changedKeys returns sorted keys that are added, removed or changed between the maps.
+func changedKeys[K ~string, V any](desired, live map[K]V, equal func(a, b V) bool) []string {
+	var keys []string
+	for k, v := range desired {
+		if lv, found := live[k]; !found || !equal(v, lv) {
+			keys = append(keys, string(k))
+		}
+	}
+	for k := range live {
+		if _, found := desired[k]; !found {
+			keys = append(keys, string(k))
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func updateNode(kubeClient kubernetes.Interface, desired *corev1.Node) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := kubeClient.CoreV1().Nodes().Get(context.TODO(), desired.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for k := range managedLabels(*node) {
+			delete(node.Labels, k)
+		}
+		if node.Labels == nil {
+			node.Labels = make(map[string]string)
+		}
+		maps.Copy(node.Labels, desired.Labels)
+		for k := range managedAnnotations(*node) {
+			delete(node.Annotations, k)
+		}
+		if node.Annotations == nil {
+			node.Annotations = make(map[string]string)
+		}
+		maps.Copy(node.Annotations, desired.Annotations)
+		taints := slices.Clone(desired.Spec.Taints)
+		for _, taint := range node.Spec.Taints {
+			if isSystemTaint(taint) {
+				taints = append(taints, taint)
+			}
+		}
+		node.Spec.Taints = taints
+		node, err = kubeClient.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		node.Status.Capacity = desired.Status.Capacity
+		node.Status.Allocatable = desired.Status.Allocatable
+		liveFields := nodeInfoFields(&node.Status.NodeInfo)
+		for name, value := range nodeInfoFields(&desired.Status.NodeInfo) {
+			if len(*value) > 0 {
+				*liveFields[name] = *value
+			}
+		}
+		_, err = kubeClient.CoreV1().Nodes().UpdateStatus(context.TODO(), node, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func deleteNode(kubeClient kubernetes.Interface, name string) error {
+	return retry.OnError(nodeCreationBackoff, isRetriableError, func() error {
+		return kubeClient.CoreV1().Nodes().Delete(context.TODO(), name, metav1.DeleteOptions{})
+	})
+}
+
+func listKemuNodes(kubeClient kubernetes.Interface) ([]corev1.Node, error) {
+	nodes, err := kubeClient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=true", ManagedByKemuLabel),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
+
+func planAddons(plan *Plan, kubeClient kubernetes.Interface, kubeconfig string) error {
+	desired := desiredAddons(plan.Config)
+	lookup := slices.Clone(desired)
+	if plan.Record != nil && plan.Record.Status != nil {
+		for _, addon := range plan.Record.Status.Addons {
+			lookup = append(lookup, api.ClusterAddon{Name: addon.Name, Namespace: addon.Namespace})
+		}
+	}
+	releases, err := listReleases(kubeClient, kubeconfig, lookup, action.ListDeployed)
+	if err != nil {
+		return err
+	}
+
+	desiredKeys := make(map[string]bool)
+	for _, addon := range desired {
+		key := addon.Namespace + "/" + addon.Name
+		desiredKeys[key] = true
+
+		rel, found := releases[key]
+		if !found {
+			plan.AddonsToInstall = append(plan.AddonsToInstall, addon)
+			continue
+		}
+		changes, err := addonChanges(addon, rel)
+		if err != nil {
+			return err
+		}
+		if len(changes) > 0 {
+			plan.AddonsToUpgrade = append(plan.AddonsToUpgrade, AddonChange{Addon: addon, Changes: changes})
+		}
+	}
+
+	// Releases previously installed by KEMU are either labeled or listed in
+	// the cluster record.
+	recorded := make(map[string]bool)
+	if plan.Record != nil && plan.Record.Status != nil {
+		for _, addon := range plan.Record.Status.Addons {
+			recorded[addon.Namespace+"/"+addon.Name] = true
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(releases)) {
+		rel := releases[key]
+		if !desiredKeys[key] && (rel.Labels[ManagedByKemuLabel] == "true" || recorded[key]) {
+			plan.AddonsToUninstall = append(plan.AddonsToUninstall, api.ClusterAddon{Name: rel.Name, Namespace: rel.Namespace})
+		}
+	}
+	return nil
+}
+
+// listReleases returns Helm releases in the given states keyed by
+// namespace/name from the namespaces of the provided addons and the
+// namespaces containing releases previously installed by KEMU.
+func listReleases(kubeClient kubernetes.Interface, kubeconfig string, addons []api.ClusterAddon, states action.ListStates) (map[string]*release.Release, error) {
+	namespaces := make(map[string]bool)
+	for _, addon := range addons {
+		namespaces[addon.Namespace] = true
+	}
+
+	// Helm stores release labels on its storage secrets which allows finding
+	// KEMU-managed releases across all namespaces.
+	secrets, err := kubeClient.CoreV1().Secrets("").List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("owner=helm,%s=true", ManagedByKemuLabel),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range secrets.Items {
+		namespaces[secret.Namespace] = true
+	}
+
+	releases := make(map[string]*release.Release)
+	for _, namespace := range slices.Sorted(maps.Keys(namespaces)) {
+		helmClient, err := helmClientFromConfig(kubeconfig, namespace)
+		if err != nil {
+			return nil, err
+		}
+		listed, err := helmClient.ListReleasesByStateMask(states)
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range listed {
+			releases[rel.Namespace+"/"+rel.Name] = rel
+		}
+	}
+	return releases, nil
+}
+
+// addonChanges lists the differences between the addon and its deployed release.
+func addonChanges(addon api.ClusterAddon, rel *release.Release) ([]string, error) {
+	var changes []string
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		// Local charts are named by their metadata rather than their path,
+		// e.g. foo-1.0.0.tgz, so only repository charts are compared by name.
+		if name := path.Base(addon.Chart); !addon.IsLocalChart() && name != rel.Chart.Metadata.Name {
+			changes = append(changes, fmt.Sprintf("chart %s -> %s", rel.Chart.Metadata.Name, name))
+		}
+		if addon.Version != "" && addon.Version != rel.Chart.Metadata.Version {
+			changes = append(changes, fmt.Sprintf("version %s -> %s", rel.Chart.Metadata.Version, addon.Version))
+		}
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal([]byte(addon.ValuesObject), &values); err != nil {
+		return nil, fmt.Errorf("addon %s/%s: %w", addon.Namespace, addon.Name, err)
+	}
+	equal, err := equalValues(values, rel.Config)
+	if err != nil {
+		return nil, err
+	}
+	if !equal {
+		changes = append(changes, "values")
+	}
+	return changes, nil
+}
+
+// equalValues compares Helm values after normalizing them through JSON so
+// that numbers and nested maps decoded by different libraries match.
+func equalValues(a, b map[string]interface{}) (bool, error) {
+	if len(a) == 0 && len(b) == 0 {
+		return true, nil
+	}
+	normalize := func(v map[string]interface{}) (interface{}, error) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		var out interface{}
+		if err = json.Unmarshal(data, &out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	na, err := normalize(a)
+	if err != nil {
+		return false, err
+	}
+	nb, err := normalize(b)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(na, nb), nil
+}
+
+func isKWOKAddon(addon api.ClusterAddon) bool {
+	return slices.ContainsFunc(kwokAddons(api.KWOK{}), func(a api.ClusterAddon) bool {
+		return a.Name == addon.Name && a.Namespace == addon.Namespace
+	})
+}
@@ -0,0 +1,56 @@
+package cluster
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/datastrophic/kemu/pkg/api"
+)
+
+// embeddedCharts contains the Helm charts shipped inside the KEMU binary so
+// that clusters can be bootstrapped without access to chart repositories.
+//
+//go:embed all:charts
+var embeddedCharts embed.FS
+
+// extractEmbeddedChart writes the embedded chart to a temporary directory
+// and returns its path along with a function removing it.
+func extractEmbeddedChart(chart string) (string, func(), error) {
+	name := strings.TrimPrefix(chart, api.EmbeddedChartPrefix)
+	root := path.Join("charts", name)
+	if _, err := fs.Stat(embeddedCharts, path.Join(root, "Chart.yaml")); err != nil {
+		return "", nil, fmt.Errorf("embedded chart %q not found", name)
+	}
+
+	dir, err := os.MkdirTemp("", "kemu-chart-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	chartDir := filepath.Join(dir, name)
+	err = fs.WalkDir(embeddedCharts, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(chartDir, filepath.FromSlash(strings.TrimPrefix(p, root)))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		data, err := embeddedCharts.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0o644)
+	})
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return chartDir, cleanup, nil
+}
@@ -0,0 +1,303 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/datastrophic/kemu/pkg/api"
+	"helm.sh/helm/v3/pkg/action"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+const kwokControllerDeployment = "kwok-controller"
+
+// ClusterInfo is a short summary of a KEMU cluster found on the machine.
+type ClusterInfo struct {
+	Name       string
+	CreatedAt  time.Time
+	Nodes      int
+	Kubeconfig string
+}
+
+// ClusterDescription summarizes the contents of a KEMU cluster.
+type ClusterDescription struct {
+	Name        string
+	KemuVersion string
+	CreatedAt   time.Time
+
+	NodeGroups []NodeGroupDescription
+	Resources  []ResourceDescription
+	Addons     []AddonDescription
+
+	KWOKController string
+}
+
+// NodeGroupDescription is the number of nodes of a node group in a zone.
+type NodeGroupDescription struct {
+	Name  string
+	Zone  string
+	Nodes int
+	Ready int
+}
+
+// ResourceDescription is the total capacity and allocatable amount of a
+// resource across all KEMU-managed nodes.
+type ResourceDescription struct {
+	Name        string
+	Capacity    resource.Quantity
+	Allocatable resource.Quantity
+}
+
+// AddonDescription is a Helm release in the cluster.
+type AddonDescription struct {
+	Name      string
+	Namespace string
+	Chart     string
+	Version   string
+	Status    string
+}
+
+// ListKemuClusters returns Kind clusters on the machine that were created by
+// KEMU, identified by the presence of the cluster config record.
+func ListKemuClusters() ([]ClusterInfo, error) {
+	names, err := listKindClusters()
+	if err != nil {
+		return nil, err
+	}
+
+	var clusters []ClusterInfo
+	for _, name := range names {
+		info, err := kemuClusterInfo(name)
+		if err != nil {
+			slog.Debug("skipping kind cluster", "name", name, "error", err)
+			continue
+		}
+		if info != nil {
+			clusters = append(clusters, *info)
+		}
+	}
+	return clusters, nil
+}
+
+func kemuClusterInfo(name string) (*ClusterInfo, error) {
+	kubeconfig, err := kindKubeconfig(name)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(kubeconfig)
+
+	kubeClient, err := kubeClientFromConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	record, err := loadClusterRecord(kubeClient)
+	if err != nil || record == nil || record.Status == nil {
+		return nil, err
+	}
+	nodes, err := listKemuNodes(kubeClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClusterInfo{
+		Name:       name,
+		CreatedAt:  record.Status.CreatedAt,
+		Nodes:      len(nodes),
+		Kubeconfig: record.Status.Kubeconfig,
+	}, nil
+}
+
+// PrintClusters writes the list of clusters as a table.
+func PrintClusters(w io.Writer, clusters []ClusterInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tAGE\tNODES\tKUBECONFIG")
+	for _, c := range clusters {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", c.Name, duration.HumanDuration(time.Since(c.CreatedAt)), c.Nodes, c.Kubeconfig)
+	}
+	return tw.Flush()
+}
+
+// DescribeKemuCluster collects node groups, capacity, addons and KWOK
+// controller health of the cluster. When kubeconfig is empty, the kubeconfig
+// of the Kind cluster with the provided name is used.
+func DescribeKemuCluster(name, kubeconfig string) (*ClusterDescription, error) {
+	if len(kubeconfig) == 0 {
+		path, err := kindKubeconfig(name)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(path)
+		kubeconfig = path
+	}
+
+	kubeClient, err := kubeClientFromConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	description := &ClusterDescription{Name: name}
+	record, err := loadClusterRecord(kubeClient)
+	if err != nil {
+		return nil, err
+	}
+	if record != nil && record.Status != nil {
+		description.Name = record.Status.ClusterName
+		description.KemuVersion = record.Status.KemuVersion
+		description.CreatedAt = record.Status.CreatedAt
+	}
+
+	nodes, err := listKemuNodes(kubeClient)
+	if err != nil {
+		return nil, err
+	}
+	description.NodeGroups, description.Resources = describeNodes(nodes)
+
+	var lookup []api.ClusterAddon
+	if record != nil {
+		lookup = desiredAddons(*record)
+	} else {
+		lookup = desiredAddons(api.ClusterConfig{})
+	}
+	releases, err := listReleases(kubeClient, kubeconfig, lookup, action.ListAll)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range slices.Sorted(maps.Keys(releases)) {
+		rel := releases[key]
+		addon := AddonDescription{Name: rel.Name, Namespace: rel.Namespace}
+		if rel.Chart != nil && rel.Chart.Metadata != nil {
+			addon.Chart = rel.Chart.Metadata.Name
+			addon.Version = rel.Chart.Metadata.Version
+		}
+		if rel.Info != nil {
+			addon.Status = rel.Info.Status.String()
+		}
+		description.Addons = append(description.Addons, addon)
+	}
+
+	deployment, err := kubeClient.AppsV1().Deployments(ClusterRecordNamespace).Get(context.TODO(), kwokControllerDeployment, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		description.KWOKController = "not installed"
+	case err != nil:
+		return nil, err
+	default:
+		desired := int32(1)
+		if deployment.Spec.Replicas != nil {
+			desired = *deployment.Spec.Replicas
+		}
+		health := "healthy"
+		if deployment.Status.ReadyReplicas < desired {
+			health = "unhealthy"
+		}
+		description.KWOKController = fmt.Sprintf("%s (%d/%d replicas ready)", health, deployment.Status.ReadyReplicas, desired)
+	}
+
+	return description, nil
+}
+
+// describeNodes groups nodes by node group and zone and sums up their resources.
+func describeNodes(nodes []corev1.Node) ([]NodeGroupDescription, []ResourceDescription) {
+	groups := make(map[string]*NodeGroupDescription)
+	capacity := make(corev1.ResourceList)
+	allocatable := make(corev1.ResourceList)
+
+	for _, node := range nodes {
+		name := node.Labels[NodeGroupLabel]
+		if len(name) == 0 {
+			name = node.Labels[InstanceTypeLabel]
+		}
+		zone := node.Labels[ZoneLabel]
+		key := name + "/" + zone
+		if groups[key] == nil {
+			groups[key] = &NodeGroupDescription{Name: name, Zone: zone}
+		}
+		groups[key].Nodes++
+		if isNodeReady(node) {
+			groups[key].Ready++
+		}
+
+		addResources(capacity, node.Status.Capacity)
+		addResources(allocatable, node.Status.Allocatable)
+	}
+
+	var nodeGroups []NodeGroupDescription
+	for _, key := range slices.Sorted(maps.Keys(groups)) {
+		nodeGroups = append(nodeGroups, *groups[key])
+	}
+
+	var resources []ResourceDescription
+	for _, name := range slices.Sorted(maps.Keys(capacity)) {
+		resources = append(resources, ResourceDescription{
+			Name:        string(name),
+			Capacity:    capacity[name],
+			Allocatable: allocatable[name],
+		})
+	}
+	return nodeGroups, resources
+}
+
+func addResources(total, resources corev1.ResourceList) {
+	for name, quantity := range resources {
+		sum := total[name]
+		sum.Add(quantity)
+		total[name] = sum
+	}
+}
+
+func isNodeReady(node corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// Print writes the cluster description in a human-readable form.
+func (d *ClusterDescription) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+
+	fmt.Fprintf(tw, "Name:\t%s\n", d.Name)
+	if !d.CreatedAt.IsZero() {
+		fmt.Fprintf(tw, "Created:\t%s (%s ago)\n", d.CreatedAt.Format(time.RFC3339), duration.HumanDuration(time.Since(d.CreatedAt)))
+	}
+	if len(d.KemuVersion) > 0 {
+		fmt.Fprintf(tw, "KEMU Version:\t%s\n", d.KemuVersion)
+	}
+	fmt.Fprintf(tw, "KWOK Controller:\t%s\n", d.KWOKController)
+
+	fmt.Fprintln(tw, "\nNode Groups:")
+	fmt.Fprintln(tw, "  NAME\tZONE\tNODES\tREADY")
+	total := 0
+	for _, ng := range d.NodeGroups {
+		fmt.Fprintf(tw, "  %s\t%s\t%d\t%d\n", ng.Name, ng.Zone, ng.Nodes, ng.Ready)
+		total += ng.Nodes
+	}
+	fmt.Fprintf(tw, "  Total\t\t%d\t\n", total)
+
+	fmt.Fprintln(tw, "\nResources:")
+	fmt.Fprintln(tw, "  RESOURCE\tCAPACITY\tALLOCATABLE")
+	for _, r := range d.Resources {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", r.Name, r.Capacity.String(), r.Allocatable.String())
+	}
+
+	fmt.Fprintln(tw, "\nAddons:")
+	fmt.Fprintln(tw, "  NAMESPACE\tNAME\tCHART\tVERSION\tSTATUS")
+	for _, a := range d.Addons {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", a.Namespace, a.Name, a.Chart, a.Version, a.Status)
+	}
+
+	return tw.Flush()
+}
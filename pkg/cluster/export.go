@@ -0,0 +1,195 @@
+package cluster
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/datastrophic/kemu/pkg/api"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ExportKemuCluster builds a ClusterConfig recreating the running cluster
+// from its KEMU-managed nodes and Helm releases, including changes made with
+// kubectl or kemu scale since the cluster was created. Settings that can't be
+// read from the cluster, such as the Kind config and KWOK settings, are
+// taken from the cluster record.
+func ExportKemuCluster(name, kubeconfig string) (api.ClusterConfig, error) {
+	if len(kubeconfig) == 0 {
+		path, err := kindKubeconfig(name)
+		if err != nil {
+			return api.ClusterConfig{}, err
+		}
+		defer os.Remove(path)
+		kubeconfig = path
+	}
+
+	kubeClient, err := kubeClientFromConfig(kubeconfig)
+	if err != nil {
+		return api.ClusterConfig{}, err
+	}
+	record, err := loadClusterRecord(kubeClient)
+	if err != nil {
+		return api.ClusterConfig{}, err
+	}
+	version, err := controlPlaneVersion(kubeClient)
+	if err != nil {
+		return api.ClusterConfig{}, err
+	}
+	nodes, err := listKemuNodes(kubeClient)
+	if err != nil {
+		return api.ClusterConfig{}, err
+	}
+
+	var lookup []api.ClusterAddon
+	if record != nil {
+		lookup = append(lookup, record.Spec.ClusterAddons...)
+		if record.Status != nil {
+			for _, addon := range record.Status.Addons {
+				lookup = append(lookup, api.ClusterAddon{Name: addon.Name, Namespace: addon.Namespace})
+			}
+		}
+	}
+	releases, err := listReleases(kubeClient, kubeconfig, lookup, action.ListDeployed)
+	if err != nil {
+		return api.ClusterConfig{}, err
+	}
+
+	config := api.ClusterConfig{
+		APIVersion: api.APIVersion,
+		Kind:       api.ClusterConfigKind,
+	}
+	if record != nil {
+		config.Spec.KindConfig = record.Spec.KindConfig
+		config.Spec.NodeCreation = record.Spec.NodeCreation
+		config.Spec.Images = record.Spec.Images
+		config.Spec.KWOK = record.Spec.KWOK
+	}
+	config.Spec.NodeGroups = ExportNodeGroups(nodes, record, version)
+	if config.Spec.ClusterAddons, err = exportAddons(releases, record); err != nil {
+		return api.ClusterConfig{}, err
+	}
+
+	if err = config.Validate(); err != nil {
+		return api.ClusterConfig{}, fmt.Errorf("exported cluster config is invalid:\n%w", err)
+	}
+	slog.Info("exported cluster", "nodes", len(nodes), "node groups", len(config.Spec.NodeGroups), "addons", len(config.Spec.ClusterAddons))
+	return config, nil
+}
+
+// ExportNodeGroups groups the KEMU-managed nodes back into node groups by
+// node group, zone, and node template. Nodes of a node group that no longer
+// share the template, e.g. after relabelling some of them, become separate
+// node groups. Zone topologies are taken from the cluster record, if any.
+// Kubelet versions equal to kubeletVersion are omitted so that the nodes
+// follow the control plane.
+func ExportNodeGroups(nodes []corev1.Node, record *api.ClusterConfig, kubeletVersion string) []api.NodeGroup {
+	topologies := make(map[string]*api.Topology)
+	if record != nil {
+		for _, nodeGroup := range record.Spec.NodeGroups {
+			for _, p := range nodeGroup.Placement {
+				if p.Topology != nil {
+					topologies[nodeGroup.Name+"/"+p.AvailabilityZone] = p.Topology
+				}
+			}
+		}
+	}
+
+	variants := make(nodeGroupVariants)
+	for _, node := range nodes {
+		nodeGroup := nodeGroupFromNode(node)
+		zone := node.Labels[ZoneLabel]
+		topology := topologies[nodeGroup.Name+"/"+zone]
+		template := exportedTemplate(nodeGroup.NodeTemplate, topology, kubeletVersion)
+		variants.add(nodeGroup.Name, node.Labels[InstanceTypeLabel], template, api.Placement{
+			AvailabilityZone: zone,
+			Region:           node.Labels[RegionLabel],
+			Topology:         topology,
+		})
+	}
+	return variants.nodeGroups()
+}
+
+// exportedTemplate omits the topology labels and the system info matching
+// the defaults from the node template.
+func exportedTemplate(template api.NodeTemplate, topology *api.Topology, kubeletVersion string) api.NodeTemplate {
+	if topology != nil {
+		for _, level := range topology.Levels {
+			delete(template.Labels, level.Label)
+		}
+	}
+	// The instance type label is set from the node group name unless the
+	// node group is renamed.
+	delete(template.Labels, InstanceTypeLabel)
+	if len(template.Labels) == 0 {
+		template.Labels = nil
+	}
+
+	if template.NodeInfo.KubeletVersion == kubeletVersion {
+		template.NodeInfo.KubeletVersion = ""
+	}
+	if template.NodeInfo.Architecture == DefaultNodeArchitecture {
+		template.NodeInfo.Architecture = ""
+	}
+	if template.NodeInfo.OperatingSystem == DefaultNodeOperatingSystem {
+		template.NodeInfo.OperatingSystem = ""
+	}
+	return template
+}
+
+// exportAddons returns the Helm releases installed by KEMU as addons, except
+// the KWOK releases which follow the KWOK settings. Chart repositories aren't
+// stored in releases, so they are taken from the cluster record.
+func exportAddons(releases map[string]*release.Release, record *api.ClusterConfig) ([]api.ClusterAddon, error) {
+	recorded := make(map[string]api.ClusterAddon)
+	statuses := make(map[string]api.AddonStatus)
+	if record != nil {
+		for _, addon := range record.Spec.ClusterAddons {
+			recorded[addon.Namespace+"/"+addon.Name] = addon
+		}
+		if record.Status != nil {
+			for _, status := range record.Status.Addons {
+				statuses[status.Namespace+"/"+status.Name] = status
+			}
+		}
+	}
+
+	var addons []api.ClusterAddon
+	for _, key := range slices.Sorted(maps.Keys(releases)) {
+		rel := releases[key]
+		addon, found := recorded[key]
+		if !found {
+			status, installed := statuses[key]
+			if !installed && rel.Labels[ManagedByKemuLabel] != "true" {
+				continue
+			}
+			addon = api.ClusterAddon{Name: rel.Name, Namespace: rel.Namespace, Chart: status.Chart}
+			if isKWOKAddon(addon) {
+				continue
+			}
+		}
+		if len(addon.Chart) == 0 || (!addon.IsLocalChart() && (len(addon.RepoName) == 0 || len(addon.RepoURL) == 0)) {
+			slog.Warn("skipping addon with unknown chart repository", "name", rel.Name, "namespace", rel.Namespace)
+			continue
+		}
+
+		if rel.Chart != nil && rel.Chart.Metadata != nil && !addon.IsLocalChart() {
+			addon.Version = rel.Chart.Metadata.Version
+		}
+		addon.ValuesObject = ""
+		if len(rel.Config) > 0 {
+			values, err := yaml.Marshal(rel.Config)
+			if err != nil {
+				return nil, fmt.Errorf("addon %s: %w", key, err)
+			}
+			addon.ValuesObject = string(values)
+		}
+		addons = append(addons, addon)
+	}
+	return addons, nil
+}
@@ -0,0 +1,283 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/datastrophic/kemu/pkg/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// Labels used by older clusters for the instance type and zone.
+	betaInstanceTypeLabel = "beta.kubernetes.io/instance-type"
+	betaZoneLabel         = "failure-domain.beta.kubernetes.io/zone"
+
+	// importedDefaultName is used for nodes without an instance type or zone.
+	importedDefaultName = "default"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// ImportOptions configure how the nodes of a cluster are turned into node
+// groups.
+type ImportOptions struct {
+	// From is a file with the output of kubectl get nodes -o yaml or -o json.
+	From string
+	// Kubeconfig of the cluster to read the nodes from when From is not set.
+	Kubeconfig string
+	// Labels and Taints list the label and taint keys kept on the node groups.
+	// Keys ending with * match by prefix, e.g. cloud.google.com/*.
+	Labels []string
+	Taints []string
+}
+
+// ImportNodes builds a ClusterConfig reproducing the shape of a node
+// inventory. Nodes are grouped by instance type and zone. Nodes of the same
+// instance type that differ in capacity, allowlisted labels or taints, or
+// system info become separate node groups. Names, addresses, annotations,
+// and other identifying data aren't carried over.
+func ImportNodes(options ImportOptions) (api.ClusterConfig, error) {
+	var nodes []corev1.Node
+	var err error
+	if len(options.From) > 0 {
+		nodes, err = nodesFromFile(options.From)
+	} else {
+		nodes, err = nodesFromCluster(options.Kubeconfig)
+	}
+	if err != nil {
+		return api.ClusterConfig{}, err
+	}
+	if len(nodes) == 0 {
+		return api.ClusterConfig{}, fmt.Errorf("no nodes to import")
+	}
+
+	config := api.ClusterConfig{
+		APIVersion: api.APIVersion,
+		Kind:       api.ClusterConfigKind,
+		Spec:       api.ClusterSpec{NodeGroups: importNodeGroups(nodes, options)},
+	}
+	if err = config.Validate(); err != nil {
+		return api.ClusterConfig{}, fmt.Errorf("imported cluster config is invalid:\n%w", err)
+	}
+	slog.Info("imported nodes", "nodes", len(nodes), "node groups", len(config.Spec.NodeGroups))
+	return config, nil
+}
+
+func nodesFromFile(path string) ([]corev1.Node, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// kubectl prints a List of nodes, or a single Node when a name is given.
+	var list corev1.NodeList
+	if err = yaml.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse nodes from %q: %w", path, err)
+	}
+	if list.Kind == "Node" {
+		var node corev1.Node
+		if err = yaml.Unmarshal(body, &node); err != nil {
+			return nil, fmt.Errorf("failed to parse node from %q: %w", path, err)
+		}
+		return []corev1.Node{node}, nil
+	}
+	return list.Items, nil
+}
+
+func nodesFromCluster(kubeconfig string) ([]corev1.Node, error) {
+	kubeClient, err := kubeClientFromConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := kubeClient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
+
+func importNodeGroups(nodes []corev1.Node, options ImportOptions) []api.NodeGroup {
+	variants := make(nodeGroupVariants)
+	for _, node := range nodes {
+		instanceType := firstLabel(node, InstanceTypeLabel, betaInstanceTypeLabel)
+		variants.add(importedName(instanceType), instanceType, importedTemplate(node, options), api.Placement{
+			AvailabilityZone: importedName(firstLabel(node, ZoneLabel, betaZoneLabel)),
+			Region:           node.Labels[RegionLabel],
+		})
+	}
+	return variants.nodeGroups()
+}
+
+// nodeGroupVariants collects nodes into node groups by their node template.
+// Nodes sharing a name but differing in the template become separate node
+// groups named <name>, <name>-2, and so on, the largest one first.
+type nodeGroupVariants map[string]*nodeGroupVariant
+
+type nodeGroupVariant struct {
+	name         string
+	instanceType string
+	template     api.NodeTemplate
+	placement    map[string]*api.Placement
+	nodes        int
+}
+
+// maxNameLength returns the length of the longest node group name that keeps
+// the node names of the variant, <name>-<zone>-<i>, valid hostname labels.
+func (v *nodeGroupVariant) maxNameLength() int {
+	length := validation.DNS1123LabelMaxLength
+	for zone, p := range v.placement {
+		length = min(length, validation.DNS1123LabelMaxLength-len(fmt.Sprintf("-%s-%d", zone, p.Replicas-1)))
+	}
+	return length
+}
+
+// add counts a node of the variant in the zone of the placement.
+func (v nodeGroupVariants) add(name, instanceType string, template api.NodeTemplate, placement api.Placement) {
+	// Maps are printed with sorted keys, so equal templates have equal keys.
+	key := fmt.Sprintf("%s %s %+v", name, instanceType, template)
+	variant, found := v[key]
+	if !found {
+		variant = &nodeGroupVariant{name: name, instanceType: instanceType, template: template, placement: make(map[string]*api.Placement)}
+		v[key] = variant
+	}
+	if p, found := variant.placement[placement.AvailabilityZone]; found {
+		p.Replicas++
+	} else {
+		placement.Replicas = 1
+		variant.placement[placement.AvailabilityZone] = &placement
+	}
+	variant.nodes++
+}
+
+// nodeGroups returns the node groups sorted by name with zones sorted by name.
+func (v nodeGroupVariants) nodeGroups() []api.NodeGroup {
+	ordered := slices.SortedFunc(maps.Keys(v), func(a, b string) int {
+		if v[a].nodes != v[b].nodes {
+			return v[b].nodes - v[a].nodes
+		}
+		return strings.Compare(a, b)
+	})
+	var nodeGroups []api.NodeGroup
+	names := make(map[string]bool)
+	for _, key := range ordered {
+		variant := v[key]
+		maxLength := variant.maxNameLength()
+		name := importedNodeGroupName(variant.name, "", maxLength)
+		for i := 2; names[name]; i++ {
+			name = importedNodeGroupName(variant.name, fmt.Sprintf("-%d", i), maxLength)
+		}
+		names[name] = true
+
+		nodeGroup := api.NodeGroup{Name: name, NodeTemplate: variant.template}
+		for _, zone := range slices.Sorted(maps.Keys(variant.placement)) {
+			nodeGroup.Placement = append(nodeGroup.Placement, *variant.placement[zone])
+		}
+		// The instance type label defaults to the node group name, so other
+		// instance types are kept in the template.
+		if len(variant.instanceType) > 0 && variant.instanceType != name {
+			nodeGroup.NodeTemplate.Labels = maps.Clone(nodeGroup.NodeTemplate.Labels)
+			if nodeGroup.NodeTemplate.Labels == nil {
+				nodeGroup.NodeTemplate.Labels = make(map[string]string)
+			}
+			nodeGroup.NodeTemplate.Labels[InstanceTypeLabel] = variant.instanceType
+		}
+		nodeGroups = append(nodeGroups, nodeGroup)
+	}
+	slices.SortFunc(nodeGroups, func(a, b api.NodeGroup) int { return strings.Compare(a.Name, b.Name) })
+	return nodeGroups
+}
+
+// importedTemplate returns the node template of the node with the
+// allowlisted labels and taints only.
+func importedTemplate(node corev1.Node, options ImportOptions) api.NodeTemplate {
+	template := api.NodeTemplate{
+		Capacity:    importedResources(node.Status.Capacity),
+		Allocatable: explicitAllocatable(node),
+		NodeInfo:    nodeInfoFromNode(node),
+	}
+	for name := range template.Allocatable {
+		if _, found := template.Capacity[name]; !found {
+			delete(template.Allocatable, name)
+		}
+	}
+	// The kubelet version follows the control plane of the emulated cluster.
+	template.NodeInfo.KubeletVersion = ""
+
+	for k, v := range node.Labels {
+		if slices.Contains(generatedNodeLabels, k) || k == betaInstanceTypeLabel || k == betaZoneLabel || !matchesKey(options.Labels, k) {
+			continue
+		}
+		if template.Labels == nil {
+			template.Labels = make(map[string]string)
+		}
+		template.Labels[k] = v
+	}
+	for _, taint := range node.Spec.Taints {
+		if isSystemTaint(taint) || !matchesKey(options.Taints, taint.Key) {
+			continue
+		}
+		template.Taints = append(template.Taints, api.Taint{Key: taint.Key, Value: taint.Value, Effect: string(taint.Effect)})
+	}
+	slices.SortFunc(template.Taints, func(a, b api.Taint) int {
+		return strings.Compare(a.Key+":"+a.Effect, b.Key+":"+b.Effect)
+	})
+	return template
+}
+
+// importedResources returns the non-zero resources that emulated nodes can
+// report, e.g. without the attachable-volumes-* resources of older clusters.
+func importedResources(resources corev1.ResourceList) api.Resources {
+	out := make(api.Resources)
+	for name, quantity := range resources {
+		if quantity.IsZero() || (!strings.Contains(string(name), "/") && !api.IsStandardResourceName(string(name))) {
+			continue
+		}
+		out[string(name)] = quantity.String()
+	}
+	return out
+}
+
+func matchesKey(allowlist []string, key string) bool {
+	return slices.ContainsFunc(allowlist, func(allowed string) bool {
+		if prefix, found := strings.CutSuffix(allowed, "*"); found {
+			return strings.HasPrefix(key, prefix)
+		}
+		return allowed == key
+	})
+}
+
+func firstLabel(node corev1.Node, keys ...string) string {
+	for _, key := range keys {
+		if v, found := node.Labels[key]; found {
+			return v
+		}
+	}
+	return ""
+}
+
+// importedName turns an instance type or zone into a valid node group or
+// zone name, e.g. p5.48xlarge into p5-48xlarge.
+func importedName(value string) string {
+	name := invalidNameChars.ReplaceAllString(strings.ToLower(value), "-")
+	name = strings.Trim(name[:min(len(name), validation.DNS1123LabelMaxLength)], "-")
+	if len(name) == 0 {
+		return importedDefaultName
+	}
+	return name
+}
+
+// importedNodeGroupName truncates the name so that along with the suffix,
+// e.g. -2 of the second variant, it is at most maxLength long.
+func importedNodeGroupName(name, suffix string, maxLength int) string {
+	name = strings.TrimRight(name[:max(min(len(name), maxLength-len(suffix)), 1)], "-")
+	return name + suffix
+}
@@ -1,41 +1,230 @@
 package cluster
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+
+	"github.com/datastrophic/kemu/pkg/api"
+)
+
+// Bootstrap phases of a KEMU cluster, recorded as checkpoints in the cluster
+// record as they complete.
+const (
+	PhaseControlPlane = "control-plane"
+	PhaseImages       = "images"
+	PhaseKWOK         = "kwok"
+	PhaseAddons       = "addons"
+	PhaseNodes        = "nodes"
 )
 
-func CreateKemuCluster(configPath, name, kubeconfig string) error {
+// CreateOption customizes cluster creation on top of the ClusterConfig.
+type CreateOption func(*createOptions)
+
+type createOptions struct {
+	nodeCreation     api.NodeCreation
+	cleanupOnFailure bool
+	resume           bool
+	provider         ControlPlaneProvider
+	images           api.Images
+}
+
+// WithNodeCreation overrides the node creation settings from the ClusterConfig.
+// Only non-zero fields are applied.
+func WithNodeCreation(settings api.NodeCreation) CreateOption {
+	return func(o *createOptions) {
+		o.nodeCreation = settings
+	}
+}
+
+// WithCleanupOnFailure deletes the cluster when any bootstrap phase fails.
+func WithCleanupOnFailure() CreateOption {
+	return func(o *createOptions) {
+		o.cleanupOnFailure = true
+	}
+}
+
+// WithResume continues the bootstrap of an existing cluster from the first
+// phase that has not been completed.
+func WithResume() CreateOption {
+	return func(o *createOptions) {
+		o.resume = true
+	}
+}
+
+// WithProvider sets the provider of the control plane. By default, a Kind
+// cluster is created.
+func WithProvider(provider ControlPlaneProvider) CreateOption {
+	return func(o *createOptions) {
+		o.provider = provider
+	}
+}
+
+// WithImages adds images to load into the control plane nodes on top of the
+// images listed in the ClusterConfig.
+func WithImages(images api.Images) CreateOption {
+	return func(o *createOptions) {
+		o.images = images
+	}
+}
+
+type bootstrapPhase struct {
+	name string
+	run  func() error
+}
+
+func CreateKemuCluster(configPath, name, kubeconfig string, opts ...CreateOption) error {
 	slog.Info("creating KEMU cluster", "name", name)
 	clusterConfig, err := parseKemuClusterConfig(configPath)
 	if err != nil {
 		return err
 	}
 
-	if kindClusterExists(name) {
-		return fmt.Errorf("underlying kind cluster %q already exists. it needs to be deleted first", name)
+	options := &createOptions{}
+	for _, opt := range opts {
+		opt(options)
 	}
-	if err = createKindClusterWithConfig(clusterConfig.Spec.KindConfig, name, kubeconfig); err != nil {
-		return err
+	nodeCreation := mergeNodeCreation(clusterConfig.Spec.NodeCreation, options.nodeCreation)
+	clusterConfig.Spec.NodeCreation = nodeCreation
+	clusterConfig.Spec.Images.Preload = append(clusterConfig.Spec.Images.Preload, options.images.Preload...)
+	clusterConfig.Spec.Images.Archives = append(clusterConfig.Spec.Images.Archives, options.images.Archives...)
+
+	provider := options.provider
+	if provider == nil {
+		provider = NewKindProvider(name, kubeconfig)
 	}
-	if err = InstallKWOK(kubeconfig); err != nil {
+	kubeconfig = provider.Kubeconfig()
+
+	var completed []string
+	exists, err := provider.Exists()
+	if err != nil {
 		return err
 	}
-	err = InstallOrUpgradeAddons(clusterConfig.Spec.ClusterAddons, kubeconfig)
+	if exists {
+		if !options.resume {
+			return fmt.Errorf("cluster %q already exists in the %s control plane. it needs to be deleted first or resumed with --resume", name, provider.Name())
+		}
+		if completed, err = completedPhases(provider); err != nil {
+			return err
+		}
+	} else if options.resume {
+		slog.Info("nothing to resume, cluster doesn't exist", "name", name)
+	}
+
+	phases := []bootstrapPhase{
+		{PhaseControlPlane, func() error { return provider.Create(clusterConfig.Spec.KindConfig) }},
+		{PhaseImages, func() error { return provider.LoadImages(clusterConfig.Spec.Images) }},
+		{PhaseKWOK, func() error { return InstallKWOK(clusterConfig.Spec.KWOK, kubeconfig) }},
+		{PhaseAddons, func() error { return InstallOrUpgradeAddons(clusterConfig.Spec.ClusterAddons, kubeconfig) }},
+		{PhaseNodes, func() error { return CreateClusterNodes(clusterConfig.Spec.NodeGroups, nodeCreation, kubeconfig) }},
+	}
+	for _, phase := range phases {
+		if slices.Contains(completed, phase.name) {
+			slog.Info("skipping completed bootstrap phase", "phase", phase.name)
+			continue
+		}
+		err = phase.run()
+		if err == nil {
+			completed = append(completed, phase.name)
+			err = saveCheckpoint(clusterConfig, name, provider, completed)
+		}
+		if err != nil {
+			return bootstrapFailed(name, phase.name, provider, options.cleanupOnFailure, err)
+		}
+	}
+
+	slog.Info("KEMU cluster created", "name", name)
+	return nil
+}
+
+// completedPhases returns the bootstrap phases recorded in an existing
+// cluster. The control plane phase is considered complete as it exists.
+func completedPhases(provider ControlPlaneProvider) ([]string, error) {
+	slog.Info("resuming bootstrap of existing cluster", "provider", provider.Name())
+	if err := provider.Connect(); err != nil {
+		return nil, err
+	}
+	kubeClient, err := kubeClientFromConfig(provider.Kubeconfig())
+	if err != nil {
+		return nil, err
+	}
+	record, err := loadClusterRecord(kubeClient)
+	if err != nil {
+		return nil, err
+	}
+
+	completed := []string{PhaseControlPlane}
+	if record != nil && record.Status != nil {
+		for _, phase := range record.Status.CompletedPhases {
+			if !slices.Contains(completed, phase) {
+				completed = append(completed, phase)
+			}
+		}
+	}
+	return completed, nil
+}
+
+// saveCheckpoint stores the cluster record with the bootstrap phases completed so far.
+func saveCheckpoint(clusterConfig api.ClusterConfig, name string, provider ControlPlaneProvider, completed []string) error {
+	kubeClient, err := kubeClientFromConfig(provider.Kubeconfig())
 	if err != nil {
 		return err
 	}
-	err = CreateClusterNodes(clusterConfig.Spec.NodeGroups, kubeconfig)
+	status, err := clusterStatus(kubeClient, clusterConfig, name, provider.Kubeconfig())
 	if err != nil {
 		return err
 	}
-	slog.Info("KEMU cluster created", "name", name)
-	return nil
+	provider.UpdateStatus(&status)
+	status.CompletedPhases = completed
+	return saveClusterRecord(kubeClient, clusterConfig, status)
+}
+
+// bootstrapFailed deletes the cluster when cleanup is requested, otherwise it
+// explains how to resume or remove the partially created cluster.
+func bootstrapFailed(name, phase string, provider ControlPlaneProvider, cleanup bool, err error) error {
+	err = fmt.Errorf("bootstrap phase %q of cluster %q failed: %w", phase, name, err)
+	if !cleanup {
+		return fmt.Errorf("%w\nthe cluster is left as is. rerun `kemu create-cluster` with --resume to continue or delete it with `kemu delete-cluster`", err)
+	}
+
+	slog.Info("cleaning up after failed bootstrap", "name", name)
+	if cleanupErr := provider.Delete(); cleanupErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to delete cluster %q: %w", name, cleanupErr))
+	}
+	return err
+}
+
+// mergeNodeCreation returns the base settings with non-zero override fields applied.
+func mergeNodeCreation(base, override api.NodeCreation) api.NodeCreation {
+	if override.Concurrency > 0 {
+		base.Concurrency = override.Concurrency
+	}
+	if override.QPS > 0 {
+		base.QPS = override.QPS
+	}
+	if override.Burst > 0 {
+		base.Burst = override.Burst
+	}
+	return base
+}
+
+// ValidateKemuClusterConfig parses the cluster configuration and reports all
+// problems found in it without creating anything.
+func ValidateKemuClusterConfig(configPath string) error {
+	_, err := parseKemuClusterConfig(configPath)
+	return err
 }
 
 func DeleteKemuCluster(name string) error {
-	slog.Info("deleting KEMU cluster", "name", name)
-	if err := deleteKindCluster(name); err != nil {
+	return DeleteKemuClusterWithProvider(name, NewKindProvider(name, ""))
+}
+
+// DeleteKemuClusterWithProvider deletes the cluster from the control plane
+// provided by the provider.
+func DeleteKemuClusterWithProvider(name string, provider ControlPlaneProvider) error {
+	slog.Info("deleting KEMU cluster", "name", name, "provider", provider.Name())
+	if err := provider.Delete(); err != nil {
 		return err
 	}
 	slog.Info("KEMU cluster deleted", "name", name)
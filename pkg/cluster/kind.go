@@ -1,13 +1,16 @@
 package cluster
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log/slog"
 	"os"
 	"strings"
 
+	"github.com/datastrophic/kemu/pkg/api"
 	"sigs.k8s.io/e2e-framework/klient"
+	"sigs.k8s.io/e2e-framework/pkg/types"
 	"sigs.k8s.io/e2e-framework/pkg/utils"
 	"sigs.k8s.io/e2e-framework/third_party/kind"
 )
@@ -22,6 +25,47 @@ func kindClusterExists(name string) bool {
 	return false
 }
 
+// listKindClusters returns the names of all Kind clusters on the machine.
+func listKindClusters() ([]string, error) {
+	var stdout, stderr bytes.Buffer
+	if err := utils.RunCommandWithSeperatedOutput("kind get clusters", &stdout, &stderr); err != nil {
+		return nil, fmt.Errorf("kind get clusters: %s: %w", stderr.String(), err)
+	}
+
+	var clusters []string
+	for _, c := range strings.Split(stdout.String(), "\n") {
+		if c = strings.TrimSpace(c); len(c) > 0 {
+			clusters = append(clusters, c)
+		}
+	}
+	return clusters, nil
+}
+
+// kindKubeconfig writes the kubeconfig of the Kind cluster to a temporary
+// file and returns its path. The caller is responsible for removing it.
+func kindKubeconfig(name string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	if err := utils.RunCommandWithSeperatedOutput(fmt.Sprintf("kind get kubeconfig --name %s", name), &stdout, &stderr); err != nil {
+		return "", fmt.Errorf("kind get kubeconfig: %s: %w", stderr.String(), err)
+	}
+
+	file, err := writeTempFile(stdout.String())
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return file.Name(), nil
+}
+
+// exportKindKubeconfig writes the kubeconfig of an existing Kind cluster to the provided path.
+func exportKindKubeconfig(name, kubeconfig string) error {
+	var stdout, stderr bytes.Buffer
+	if err := utils.RunCommandWithSeperatedOutput(fmt.Sprintf("kind export kubeconfig --name %s --kubeconfig %s", name, kubeconfig), &stdout, &stderr); err != nil {
+		return fmt.Errorf("kind export kubeconfig: %s: %w", stderr.String(), err)
+	}
+	return nil
+}
+
 func createKindClusterWithConfig(kindConfig string, name, kubeconfig string) error {
 	slog.Info("creating kind cluster", "name", name, "kubeconfig", kubeconfig)
 
@@ -61,6 +105,25 @@ func createKindClusterWithConfig(kindConfig string, name, kubeconfig string) err
 	return nil
 }
 
+// loadKindImages loads images from the local Docker image store and image
+// archives into the nodes of the Kind cluster.
+func loadKindImages(name string, images api.Images) error {
+	kindClusterProvider := kind.NewProvider().SetDefaults().WithName(name).(types.E2EClusterProviderWithImageLoader)
+	for _, image := range images.Preload {
+		slog.Info("loading image into kind nodes", "image", image)
+		if err := kindClusterProvider.LoadImage(context.Background(), image); err != nil {
+			return err
+		}
+	}
+	for _, archive := range images.Archives {
+		slog.Info("loading image archive into kind nodes", "archive", archive)
+		if err := kindClusterProvider.LoadImageArchive(context.Background(), archive); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func deleteKindCluster(name string) error {
 	slog.Info("deleting kind cluster", "name", name)
 	return kind.NewProvider().SetDefaults().WithName(name).Destroy(context.Background())
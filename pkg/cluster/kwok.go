@@ -2,43 +2,247 @@ package cluster
 
 import (
 	"context"
+	"embed"
+	"fmt"
 	"log/slog"
+	"slices"
 
 	"github.com/datastrophic/kemu/pkg/api"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kwok "sigs.k8s.io/kwok/pkg/apis/v1alpha1"
+	kwokclient "sigs.k8s.io/kwok/pkg/client/clientset/versioned"
 )
 
 const (
 	KWOKDurationFromAnnotation = ".metadata.annotations[\"pod-complete.stage.kwok.x-k8s.io/delay\"]"
 	KWOKStagePodComplete       = "pod-complete"
+
+	kwokControllerRelease = "kwok"
+	kwokStagesRelease     = "kwok-stage-fast"
+	kwokRepoName          = "kwok"
+	kwokRepoURL           = "https://kwok.sigs.k8s.io/charts/"
+	// embeddedKWOKVersion is the version of the KWOK charts embedded in the binary.
+	embeddedKWOKVersion = "0.2.0"
+	// helmReleaseAnnotation marks objects owned by a Helm release.
+	helmReleaseAnnotation = "meta.helm.sh/release-name"
 )
 
-var kwokAddons = []api.ClusterAddon{
-	{
-		Name:      "kwok",
-		RepoName:  "kwok",
-		RepoURL:   "https://kwok.sigs.k8s.io/charts/",
-		Namespace: "kube-system",
-		Chart:     "kwok/kwok",
-		Version:   "0.2.0",
-	},
-	{
-		Name:      "kwok-stage-fast",
-		RepoName:  "kwok",
-		RepoURL:   "https://kwok.sigs.k8s.io/charts/",
+// Pod annotations recognized by the failure stages installed for all KWOK
+// profiles. Delays are Go durations, the probability is a share from 0 to 1.
+const (
+	PodCompleteDelayAnnotation   = "pod-complete.stage.kwok.x-k8s.io/delay"
+	PodFailDelayAnnotation       = "pod-fail.stage.kwok.x-k8s.io/delay"
+	PodFailReasonAnnotation      = "pod-fail.stage.kwok.x-k8s.io/reason"
+	PodFailExitCodeAnnotation    = "pod-fail.stage.kwok.x-k8s.io/exit-code"
+	PodFailProbabilityAnnotation = "pod-fail.stage.kwok.x-k8s.io/probability"
+	PodRestartCountAnnotation    = "pod-restart.stage.kwok.x-k8s.io/count"
+	PodRestartDelayAnnotation    = "pod-restart.stage.kwok.x-k8s.io/delay"
+	PodRestartReasonAnnotation   = "pod-restart.stage.kwok.x-k8s.io/reason"
+	PodRestartExitCodeAnnotation = "pod-restart.stage.kwok.x-k8s.io/exit-code"
+)
+
+//go:embed stages/*.yaml
+var profileStageFiles embed.FS
+
+// commonStages are installed for all profiles. They restart and fail pods
+// with the annotations listed in the files and complete Pods without an owner.
+var commonStages = []string{"stages/failures.yaml", "stages/standalone-pods.yaml"}
+
+// profileStages lists the stage files of the profiles not backed by a chart.
+// Stages from later files replace stages with the same name.
+var profileStages = map[string][]string{
+	api.KWOKProfileRealistic:    {"stages/realistic-with-delays.yaml"},
+	api.KWOKProfileFailureProne: {"stages/realistic-with-delays.yaml", "stages/failure-prone.yaml"},
+}
+
+// kwokAddons returns the KWOK charts for the settings. The embedded charts
+// are used unless another version is requested. The stages chart is only
+// installed for the fast profile, other profiles are installed as stages.
+// The charts can be overridden with addons of the same name and namespace in
+// the config.
+func kwokAddons(settings api.KWOK) []api.ClusterAddon {
+	controller := api.ClusterAddon{
+		Name:         kwokControllerRelease,
+		Namespace:    "kube-system",
+		Chart:        api.EmbeddedChartPrefix + "kwok",
+		Version:      embeddedKWOKVersion,
+		ValuesObject: settings.ValuesObject,
+	}
+	stages := api.ClusterAddon{
+		Name:      kwokStagesRelease,
 		Namespace: "kube-system",
-		Chart:     "kwok/stage-fast",
-		Version:   "0.2.0",
-	},
+		Chart:     api.EmbeddedChartPrefix + "stage-fast",
+		Version:   embeddedKWOKVersion,
+	}
+	if len(settings.Version) > 0 && settings.Version != embeddedKWOKVersion {
+		controller.Chart, controller.RepoName, controller.RepoURL, controller.Version = kwokRepoName+"/kwok", kwokRepoName, kwokRepoURL, settings.Version
+		stages.Chart, stages.RepoName, stages.RepoURL, stages.Version = kwokRepoName+"/stage-fast", kwokRepoName, kwokRepoURL, settings.Version
+	}
+
+	if kwokProfile(settings) != api.KWOKProfileFast {
+		return []api.ClusterAddon{controller}
+	}
+	return []api.ClusterAddon{controller, stages}
+}
+
+func kwokProfile(settings api.KWOK) string {
+	if len(settings.Profile) == 0 {
+		return api.KWOKProfileFast
+	}
+	return settings.Profile
+}
+
+func InstallKWOK(settings api.KWOK, kubeconfig string) error {
+	slog.Info("installing kwok", "profile", kwokProfile(settings))
+	if err := pruneKWOKStages(settings, kubeconfig); err != nil {
+		return err
+	}
+	if err := InstallOrUpgradeAddons(kwokAddons(settings), kubeconfig); err != nil {
+		return err
+	}
+	return configureKWOKStages(settings, kubeconfig)
+}
+
+// kwokStages returns the stages KEMU manages on top of the KWOK charts: the
+// common stages, the stages of the profile, and the custom stages. Stages replace earlier
+// stages with the same name.
+func kwokStages(settings api.KWOK) ([]kwok.Stage, error) {
+	var stages []kwok.Stage
+	add := func(data string) error {
+		parsed, err := api.ParseStages(data)
+		if err != nil {
+			return err
+		}
+		for _, stage := range parsed {
+			if i := slices.IndexFunc(stages, func(s kwok.Stage) bool { return s.Name == stage.Name }); i >= 0 {
+				stages[i] = stage
+			} else {
+				stages = append(stages, stage)
+			}
+		}
+		return nil
+	}
+
+	for _, file := range append(slices.Clone(commonStages), profileStages[kwokProfile(settings)]...) {
+		data, err := profileStageFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err = add(string(data)); err != nil {
+			return nil, fmt.Errorf("invalid stages in %s: %w", file, err)
+		}
+	}
+	if err := add(settings.Stages); err != nil {
+		return nil, fmt.Errorf("invalid custom stages: %w", err)
+	}
+	return stages, nil
+}
+
+// configureKWOKStages creates or updates the stages of the profile and the
+// custom stages. For the fast profile, the pod-complete stage of the chart is
+// updated first.
+func configureKWOKStages(settings api.KWOK, kubeconfig string) error {
+	if kwokProfile(settings) == api.KWOKProfileFast {
+		if err := updatePodCompleteStage(kubeconfig); err != nil {
+			return err
+		}
+	}
+
+	stages, err := kwokStages(settings)
+	if err != nil {
+		return err
+	}
+	if len(stages) == 0 {
+		return nil
+	}
+	kwokClient, err := kwokClientFromConfig(kubeconfig)
+	if err != nil {
+		return err
+	}
+	slog.Info("configuring kwok stages", "count", len(stages))
+	for _, stage := range stages {
+		if err = applyStage(kwokClient, stage); err != nil {
+			return fmt.Errorf("failed to apply kwok stage %q: %w", stage.Name, err)
+		}
+	}
+	return nil
 }
 
-func InstallKWOK(kubeconfig string) error {
-	slog.Info("installing kwok")
-	if err := InstallOrUpgradeAddons(kwokAddons, kubeconfig); err != nil {
+// applyStage creates the stage or replaces the spec of the existing one. The
+// stage is labeled as managed by KEMU.
+func applyStage(kwokClient *kwokclient.Clientset, stage kwok.Stage) error {
+	stages := kwokClient.KwokV1alpha1().Stages()
+	existing, err := stages.Get(context.Background(), stage.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if stage.Labels == nil {
+			stage.Labels = make(map[string]string)
+		}
+		stage.Labels[ManagedByKemuLabel] = "true"
+		_, err = stages.Create(context.Background(), &stage, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
 		return err
 	}
 
+	existing.Spec = stage.Spec
+	if existing.Labels == nil {
+		existing.Labels = make(map[string]string)
+	}
+	existing.Labels[ManagedByKemuLabel] = "true"
+	_, err = stages.Update(context.Background(), existing, metav1.UpdateOptions{})
+	return err
+}
+
+// pruneKWOKStages deletes stages created by KEMU that are no longer part of
+// the settings. It runs before the charts are installed so stale stages don't
+// conflict with the stages of the chart.
+func pruneKWOKStages(settings api.KWOK, kubeconfig string) error {
+	desired, err := kwokStages(settings)
+	if err != nil {
+		return err
+	}
+	return deleteKWOKStages(kubeconfig, desired)
+}
+
+// deleteKWOKStages deletes the stages created by KEMU except the kept ones.
+// Stages owned by the stages chart are left to Helm, and there's nothing to
+// delete before the Stage CRD is installed.
+func deleteKWOKStages(kubeconfig string, keep []kwok.Stage) error {
+	kwokClient, err := kwokClientFromConfig(kubeconfig)
+	if err != nil {
+		return err
+	}
+	stages, err := kwokClient.KwokV1alpha1().Stages().List(context.Background(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=true", ManagedByKemuLabel),
+	})
+	if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, stage := range stages.Items {
+		if _, owned := stage.Annotations[helmReleaseAnnotation]; owned {
+			continue
+		}
+		if slices.ContainsFunc(keep, func(s kwok.Stage) bool { return s.Name == stage.Name }) {
+			continue
+		}
+		slog.Info("deleting kwok stage", "name", stage.Name)
+		err = kwokClient.KwokV1alpha1().Stages().Delete(context.Background(), stage.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// updatePodCompleteStage configures the pod-complete stage to read the delay
+// from the pod annotation instead of completing pods immediately.
+func updatePodCompleteStage(kubeconfig string) error {
 	kwokClient, err := kwokClientFromConfig(kubeconfig)
 	if err != nil {
 		return err
@@ -2,107 +2,476 @@ package cluster
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/datastrophic/kemu/pkg/api"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 )
 
 const (
 	HostnameLabel      = "kubernetes.io/hostname"
-	InstanceTypeLabel  = "node.kubernetes.io/instance-type"
+	InstanceTypeLabel  = api.InstanceTypeLabel
 	ZoneLabel          = "topology.kubernetes.io/zone"
+	RegionLabel        = "topology.kubernetes.io/region"
 	ManagedByKemuLabel = "kemu.datastrophic.io/managed"
+	NodeGroupLabel     = "kemu.datastrophic.io/node-group"
 )
 
-func CreateClusterNodes(nodeGroups []api.NodeGroup, kubeconfig string) error {
+const (
+	DefaultNodeArchitecture    = "amd64"
+	DefaultNodeOperatingSystem = "linux"
+	// DefaultKubeletVersion is reported by nodes when the version of the
+	// control plane is unknown, e.g. when rendering a config.
+	DefaultKubeletVersion = "v1.33.1"
+)
+
+// templateAnnotationsKey lists the annotations of a node that come from the
+// node template, so that the ones removed from the template can be removed
+// from the node.
+const templateAnnotationsKey = "kemu.datastrophic.io/template-annotations"
+
+// templateLabelsKey lists the labels of a node that come from the node
+// template or the topology, so that the ones removed from the config can be
+// removed from the node while labels added outside KEMU are kept.
+const templateLabelsKey = "kemu.datastrophic.io/template-labels"
+
+// defaultNodeTaint keeps pods without a matching toleration off the emulated
+// nodes unless disabled in the node template.
+var defaultNodeTaint = corev1.Taint{Key: "kwok.x-k8s.io/node", Value: "fake", Effect: corev1.TaintEffectNoSchedule}
+
+const (
+	DefaultNodeCreationConcurrency = 32
+	DefaultNodeCreationQPS         = 100
+	DefaultNodeCreationBurst       = 200
+
+	nodeProgressInterval = 500
+)
+
+// generatedNodeLabels are set by createNodeSpec on every node regardless of the node template.
+var generatedNodeLabels = []string{
+	"kubernetes.io/arch",
+	"kubernetes.io/os",
+	"kubernetes.io/role",
+	"type",
+	ManagedByKemuLabel,
+	NodeGroupLabel,
+	HostnameLabel,
+	InstanceTypeLabel,
+	ZoneLabel,
+	RegionLabel,
+}
+
+// nodeCreationBackoff is used for retrying node creation when the API server
+// throttles requests or reports a conflict.
+var nodeCreationBackoff = wait.Backoff{
+	Steps:    6,
+	Duration: 200 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.1,
+}
+
+func CreateClusterNodes(nodeGroups []api.NodeGroup, settings api.NodeCreation, kubeconfig string) error {
 	slog.Info("creating KWOK cluster nodes")
 
 	kubeClient, err := kubeClientFromConfig(kubeconfig)
 	if err != nil {
 		return err
 	}
+	version, err := controlPlaneVersion(kubeClient)
+	if err != nil {
+		return err
+	}
+
+	nodes := clusterNodeSpecs(withKubeletVersion(nodeGroups, version))
+	slog.Info("generated node specs", "node groups", len(nodeGroups), "nodes", len(nodes))
+
+	return createNodes(nodes, settings, kubeconfig)
+}
 
+// clusterNodeSpecs returns the nodes of all node groups in all zones.
+func clusterNodeSpecs(nodeGroups []api.NodeGroup) []corev1.Node {
 	var nodes []corev1.Node
 	for _, nodeGroup := range nodeGroups {
-		slog.Info("processing", "node group", nodeGroup.Name)
-		for _, placement := range nodeGroup.Placement {
+		for _, placement := range nodeGroup.ZonePlacement() {
 			nodes = append(nodes, createNodeSpecs(nodeGroup, placement)...)
 		}
 	}
+	return nodes
+}
 
-	for _, node := range nodes {
-		slog.Info("creating", "node", node.Name)
-		_, err := kubeClient.CoreV1().Nodes().Create(context.TODO(), &node, metav1.CreateOptions{})
-		if err != nil {
-			return err
+// controlPlaneVersion returns the Kubernetes version of the API server.
+func controlPlaneVersion(kubeClient kubernetes.Interface) (string, error) {
+	version, err := kubeClient.Discovery().ServerVersion()
+	if err != nil {
+		return "", fmt.Errorf("failed to get control plane version: %w", err)
+	}
+	return version.GitVersion, nil
+}
+
+// withKubeletVersion returns copies of the node groups with the kubelet
+// version set where the node template doesn't set it.
+func withKubeletVersion(nodeGroups []api.NodeGroup, version string) []api.NodeGroup {
+	out := slices.Clone(nodeGroups)
+	for i := range out {
+		if len(out[i].NodeTemplate.NodeInfo.KubeletVersion) == 0 {
+			out[i].NodeTemplate.NodeInfo.KubeletVersion = version
 		}
 	}
-	slog.Info("nodes created", "count", len(nodes))
+	return out
+}
+
+// createNodes submits the nodes to the API server using a bounded pool of
+// workers sharing a single rate-limited client.
+func createNodes(nodes []corev1.Node, settings api.NodeCreation, kubeconfig string) error {
+	settings = nodeCreationWithDefaults(settings)
+	kubeClient, err := kubeClientWithRateLimits(kubeconfig, settings.QPS, settings.Burst)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("creating nodes", "count", len(nodes), "concurrency", settings.Concurrency, "qps", settings.QPS, "burst", settings.Burst)
+	return forEachNode(nodes, settings.Concurrency, "create", func(node *corev1.Node) error {
+		return createNode(kubeClient, node)
+	})
+}
+
+// forEachNode runs the operation for every node using a bounded pool of
+// workers. Failures don't stop the remaining workers and are reported
+// together once all nodes are processed.
+func forEachNode(nodes []corev1.Node, concurrency int, verb string, op func(node *corev1.Node) error) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+		done atomic.Int64
+	)
+	queue := make(chan *corev1.Node)
+
+	for i := 0; i < min(concurrency, len(nodes)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for node := range queue {
+				if err := op(node); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("node %q: %w", node.Name, err))
+					mu.Unlock()
+					continue
+				}
+				slog.Debug(fmt.Sprintf("%s node", verb), "node", node.Name)
+				if n := done.Add(1); n%nodeProgressInterval == 0 {
+					slog.Info(fmt.Sprintf("%s nodes", verb), "processed", n, "total", len(nodes))
+				}
+			}
+		}()
+	}
+
+	for i := range nodes {
+		queue <- &nodes[i]
+	}
+	close(queue)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		slices.SortFunc(errs, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+		return fmt.Errorf("failed to %s %d of %d nodes:\n%w", verb, len(errs), len(nodes), errors.Join(errs...))
+	}
+	slog.Info(fmt.Sprintf("%s nodes finished", verb), "count", done.Load())
 	return nil
 }
 
+// createNode creates the node, treating an existing node with the same name
+// as created so that an interrupted bootstrap can be resumed.
+func createNode(kubeClient kubernetes.Interface, node *corev1.Node) error {
+	return retry.OnError(nodeCreationBackoff, isRetriableError, func() error {
+		_, err := kubeClient.CoreV1().Nodes().Create(context.TODO(), node, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+func isRetriableError(err error) bool {
+	return apierrors.IsConflict(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsServiceUnavailable(err)
+}
+
+func nodeCreationWithDefaults(settings api.NodeCreation) api.NodeCreation {
+	if settings.Concurrency == 0 {
+		settings.Concurrency = DefaultNodeCreationConcurrency
+	}
+	if settings.QPS == 0 {
+		settings.QPS = DefaultNodeCreationQPS
+	}
+	if settings.Burst == 0 {
+		settings.Burst = DefaultNodeCreationBurst
+	}
+	return settings
+}
+
 func createNodeSpecs(nodeGroup api.NodeGroup, placement api.Placement) []corev1.Node {
-	namePrefix := fmt.Sprintf("%s-%s", nodeGroup.Name, placement.AvailabilityZone)
 	var nodes []corev1.Node
-
 	for i := 0; i < placement.Replicas; i++ {
-		hostname := fmt.Sprintf("%s-%d", namePrefix, i)
+		nodes = append(nodes, createNodeSpec(nodeGroup, placement, i))
+	}
+	return nodes
+}
 
-		annotations := map[string]string{
-			"kwok.x-k8s.io/node": "fake",
-		}
+// nodeName returns the name of the i-th node of the node group in the zone.
+func nodeName(nodeGroup, zone string, i int) string {
+	return fmt.Sprintf("%s%d", nodeNamePrefix(nodeGroup, zone), i)
+}
+
+func nodeNamePrefix(nodeGroup, zone string) string {
+	return fmt.Sprintf("%s-%s-", nodeGroup, zone)
+}
 
-		labels := map[string]string{
-			"kubernetes.io/arch": "arm64",
-			"kubernetes.io/os":   "kemu",
-			"kubernetes.io/role": "agent",
-			"type":               "kwok",
-			ManagedByKemuLabel:   "true",
-			HostnameLabel:        hostname,
-			InstanceTypeLabel:    nodeGroup.Name,
-			ZoneLabel:            placement.AvailabilityZone,
+// topologyNodeName returns the name of the i-th node of the node group in
+// its innermost topology domain.
+func topologyNodeName(nodeGroup string, domain api.TopologyDomain, i int) string {
+	return fmt.Sprintf("%s-%s-%d", nodeGroup, domain.ID, i)
+}
+
+func createNodeSpec(nodeGroup api.NodeGroup, placement api.Placement, i int) corev1.Node {
+	zone := placement.AvailabilityZone
+	domains, j := placement.TopologyDomains(i)
+	hostname := nodeName(nodeGroup.Name, zone, i)
+	if len(domains) > 0 {
+		hostname = topologyNodeName(nodeGroup.Name, domains[len(domains)-1], j)
+	}
+
+	annotations := map[string]string{
+		api.KWOKNodeAnnotation: "fake",
+	}
+	for k, v := range nodeGroup.NodeTemplate.Annotations {
+		annotations[k] = v
+	}
+	if len(nodeGroup.NodeTemplate.Annotations) > 0 {
+		annotations[templateAnnotationsKey] = strings.Join(slices.Sorted(maps.Keys(nodeGroup.NodeTemplate.Annotations)), ",")
+	}
+
+	info := nodeInfoWithDefaults(nodeGroup.NodeTemplate.NodeInfo)
+	labels := map[string]string{
+		"kubernetes.io/arch": info.Architecture,
+		"kubernetes.io/os":   info.OperatingSystem,
+		"kubernetes.io/role": "agent",
+		"type":               "kwok",
+		ManagedByKemuLabel:   "true",
+		NodeGroupLabel:       nodeGroup.Name,
+		HostnameLabel:        hostname,
+		InstanceTypeLabel:    nodeGroup.Name,
+		ZoneLabel:            zone,
+	}
+	if len(placement.Region) > 0 {
+		labels[RegionLabel] = placement.Region
+	}
+	for _, domain := range domains {
+		labels[domain.Label] = domain.ID
+	}
+
+	for k, v := range nodeGroup.NodeTemplate.Labels {
+		labels[k] = v
+	}
+	if keys := templateLabelKeysOf(labels); len(keys) > 0 {
+		annotations[templateLabelsKey] = strings.Join(keys, ",")
+	}
+
+	resources := make(map[corev1.ResourceName]resource.Quantity)
+	for name, quantity := range nodeGroup.NodeTemplate.Capacity {
+		resources[corev1.ResourceName(name)] = resource.MustParse(quantity)
+	}
+
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        hostname,
+			Annotations: annotations,
+			Labels:      labels,
+		},
+		Spec: corev1.NodeSpec{
+			Taints: nodeTaints(nodeGroup.NodeTemplate),
+		},
+		Status: corev1.NodeStatus{
+			Capacity:    resources,
+			Allocatable: nodeAllocatable(nodeGroup.NodeTemplate, resources),
+			NodeInfo: corev1.NodeSystemInfo{
+				Architecture:            info.Architecture,
+				OperatingSystem:         info.OperatingSystem,
+				OSImage:                 info.OSImage,
+				KernelVersion:           info.KernelVersion,
+				ContainerRuntimeVersion: info.ContainerRuntimeVersion,
+				KubeletVersion:          info.KubeletVersion,
+				MachineID:               machineID(hostname),
+			},
+			Phase: corev1.NodeRunning,
+		},
+	}
+}
+
+func nodeInfoWithDefaults(info api.NodeInfo) api.NodeInfo {
+	if len(info.Architecture) == 0 {
+		info.Architecture = DefaultNodeArchitecture
+	}
+	if len(info.OperatingSystem) == 0 {
+		info.OperatingSystem = DefaultNodeOperatingSystem
+	}
+	if len(info.KubeletVersion) == 0 {
+		info.KubeletVersion = DefaultKubeletVersion
+	}
+	return info
+}
+
+// nodeTaints returns the default taint, unless disabled or replaced by a
+// taint with the same key and effect, followed by the template taints.
+func nodeTaints(template api.NodeTemplate) []corev1.Taint {
+	var taints []corev1.Taint
+	if !template.DisableDefaultTaint && !slices.ContainsFunc(template.Taints, func(t api.Taint) bool {
+		return t.Key == defaultNodeTaint.Key && t.Effect == string(defaultNodeTaint.Effect)
+	}) {
+		taints = append(taints, defaultNodeTaint)
+	}
+	for _, taint := range template.Taints {
+		taints = append(taints, corev1.Taint{Key: taint.Key, Value: taint.Value, Effect: corev1.TaintEffect(taint.Effect)})
+	}
+	return taints
+}
+
+// machineID derives a stable machine ID from the node name so that rendered
+// and created nodes are reproducible.
+func machineID(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:16])
+}
+
+// templateAnnotationKeys returns the keys of the node annotations that come
+// from the node template.
+func templateAnnotationKeys(node corev1.Node) []string {
+	keys := node.Annotations[templateAnnotationsKey]
+	if len(keys) == 0 {
+		return nil
+	}
+	return strings.Split(keys, ",")
+}
+
+// templateLabelKeys returns the keys of the node labels that come from the
+// node template or the topology.
+func templateLabelKeys(node corev1.Node) []string {
+	keys := node.Annotations[templateLabelsKey]
+	if len(keys) == 0 {
+		return nil
+	}
+	return strings.Split(keys, ",")
+}
+
+// templateLabelKeysOf returns the sorted keys of the labels that aren't
+// generated for every node.
+func templateLabelKeysOf(labels map[string]string) []string {
+	var keys []string
+	for k := range labels {
+		if !slices.Contains(generatedNodeLabels, k) {
+			keys = append(keys, k)
 		}
+	}
+	slices.Sort(keys)
+	return keys
+}
 
-		for k, v := range nodeGroup.NodeTemplate.Labels {
+// nodeGroupFromNode reconstructs the node group definition of a node created
+// by createNodeSpec. Labels generated by KEMU are omitted from the template.
+func nodeGroupFromNode(node corev1.Node) api.NodeGroup {
+	labels := make(map[string]string)
+	for k, v := range node.Labels {
+		if !slices.Contains(generatedNodeLabels, k) {
 			labels[k] = v
 		}
+	}
+	// Instance types from the catalog override the instance type label.
+	if instanceType := node.Labels[InstanceTypeLabel]; instanceType != node.Labels[NodeGroupLabel] {
+		labels[InstanceTypeLabel] = instanceType
+	}
 
-		resources := make(map[corev1.ResourceName]resource.Quantity)
-		for name, quantity := range nodeGroup.NodeTemplate.Capacity {
-			resources[corev1.ResourceName(name)] = resource.MustParse(quantity)
-		}
+	capacity := make(api.Resources)
+	for name, quantity := range node.Status.Capacity {
+		capacity[string(name)] = quantity.String()
+	}
 
-		node := corev1.Node{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:        hostname,
-				Annotations: annotations,
-				Labels:      labels,
-			},
-			Spec: corev1.NodeSpec{
-				Taints: []corev1.Taint{
-					{Key: "kwok.x-k8s.io/node", Effect: "NoSchedule", Value: "fake"},
-				},
-			},
-			Status: corev1.NodeStatus{
-				Capacity:    resources,
-				Allocatable: resources,
-				NodeInfo: corev1.NodeSystemInfo{
-					Architecture:    "arm64",
-					OperatingSystem: "kemu",
-					KubeletVersion:  "fake",
-					MachineID:       string(uuid.NewUUID()),
-				},
-				Phase: corev1.NodeRunning,
-			},
+	nodeGroup := api.NodeGroup{
+		Name: node.Labels[NodeGroupLabel],
+		NodeTemplate: api.NodeTemplate{
+			Capacity:    capacity,
+			Allocatable: explicitAllocatable(node),
+		},
+	}
+	if len(labels) > 0 {
+		nodeGroup.NodeTemplate.Labels = labels
+	}
+	annotations := make(map[string]string)
+	for _, k := range templateAnnotationKeys(node) {
+		if v, found := node.Annotations[k]; found {
+			annotations[k] = v
+		}
+	}
+	if len(annotations) > 0 {
+		nodeGroup.NodeTemplate.Annotations = annotations
+	}
+	nodeGroup.NodeTemplate.NodeInfo = nodeInfoFromNode(node)
+	nodeGroup.NodeTemplate.DisableDefaultTaint = true
+	for _, taint := range node.Spec.Taints {
+		if isSystemTaint(taint) {
+			continue
 		}
+		if taint.MatchTaint(&defaultNodeTaint) && taint.Value == defaultNodeTaint.Value {
+			nodeGroup.NodeTemplate.DisableDefaultTaint = false
+			continue
+		}
+		nodeGroup.NodeTemplate.Taints = append(nodeGroup.NodeTemplate.Taints, api.Taint{Key: taint.Key, Value: taint.Value, Effect: string(taint.Effect)})
+	}
+	return nodeGroup
+}
 
-		nodes = append(nodes, node)
+// explicitAllocatable returns the allocatable resources of the node that
+// differ from its capacity. Reservations can't be recovered from a node, so
+// node templates set such allocatable resources explicitly.
+func explicitAllocatable(node corev1.Node) api.Resources {
+	var allocatable api.Resources
+	for name, quantity := range node.Status.Allocatable {
+		if c, found := node.Status.Capacity[name]; found && c.Cmp(quantity) != 0 {
+			if allocatable == nil {
+				allocatable = make(api.Resources)
+			}
+			allocatable[string(name)] = quantity.String()
+		}
 	}
+	return allocatable
+}
 
-	return nodes
+func nodeInfoFromNode(node corev1.Node) api.NodeInfo {
+	nodeInfo := node.Status.NodeInfo
+	return api.NodeInfo{
+		Architecture:            nodeInfo.Architecture,
+		OperatingSystem:         nodeInfo.OperatingSystem,
+		OSImage:                 nodeInfo.OSImage,
+		KernelVersion:           nodeInfo.KernelVersion,
+		ContainerRuntimeVersion: nodeInfo.ContainerRuntimeVersion,
+		KubeletVersion:          nodeInfo.KubeletVersion,
+	}
 }
@@ -0,0 +1,275 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/datastrophic/kemu/pkg/api"
+	"helm.sh/helm/v3/pkg/action"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	ProviderKind     = "kind"
+	ProviderExisting = "existing"
+)
+
+// ControlPlaneProvider supplies the Kubernetes control plane KEMU installs
+// KWOK, addons, and emulated nodes into.
+type ControlPlaneProvider interface {
+	// Name identifies the provider in the cluster record.
+	Name() string
+	// Exists reports whether a KEMU cluster is already present.
+	Exists() (bool, error)
+	// Create makes the control plane available, using the Kind config if the
+	// provider supports it.
+	Create(kindConfig string) error
+	// LoadImages makes the images available on the control plane nodes
+	// without pulling them from a registry.
+	LoadImages(images api.Images) error
+	// Connect makes the kubeconfig of an existing control plane available.
+	Connect() error
+	// Kubeconfig returns the path of the kubeconfig file for accessing the control plane.
+	Kubeconfig() string
+	// UpdateStatus adds provider details to the cluster record status.
+	UpdateStatus(status *api.ClusterStatus)
+	// Delete removes the KEMU cluster.
+	Delete() error
+	// Close releases resources held by the provider.
+	Close() error
+}
+
+// NewControlPlaneProvider returns the provider with the given name. The kind
+// provider creates a cluster with the given name and writes its kubeconfig
+// to the provided path; the existing provider uses the cluster the kubeconfig
+// and context point to.
+func NewControlPlaneProvider(provider, name, kubeconfig, kubeContext string) (ControlPlaneProvider, error) {
+	switch provider {
+	case ProviderKind, "":
+		return NewKindProvider(name, kubeconfig), nil
+	case ProviderExisting:
+		return NewExistingProvider(kubeconfig, kubeContext)
+	default:
+		return nil, fmt.Errorf("unsupported control plane provider %q. supported providers: %s, %s", provider, ProviderKind, ProviderExisting)
+	}
+}
+
+// KindProvider runs the control plane in a Kind cluster owned by KEMU.
+type KindProvider struct {
+	name       string
+	kubeconfig string
+}
+
+func NewKindProvider(name, kubeconfig string) *KindProvider {
+	return &KindProvider{name: name, kubeconfig: kubeconfig}
+}
+
+func (p *KindProvider) Name() string { return ProviderKind }
+
+func (p *KindProvider) Exists() (bool, error) { return kindClusterExists(p.name), nil }
+
+func (p *KindProvider) Create(kindConfig string) error {
+	return createKindClusterWithConfig(kindConfig, p.name, p.kubeconfig)
+}
+
+func (p *KindProvider) LoadImages(images api.Images) error {
+	return loadKindImages(p.name, images)
+}
+
+func (p *KindProvider) Connect() error { return exportKindKubeconfig(p.name, p.kubeconfig) }
+
+func (p *KindProvider) Kubeconfig() string { return p.kubeconfig }
+
+func (p *KindProvider) UpdateStatus(status *api.ClusterStatus) { status.Provider = ProviderKind }
+
+// Delete removes the Kind cluster together with everything in it.
+func (p *KindProvider) Delete() error { return deleteKindCluster(p.name) }
+
+func (p *KindProvider) Close() error { return nil }
+
+// ExistingProvider installs KEMU into a control plane it doesn't own, such as
+// a shared dev cluster, k3d, or a kwokctl cluster.
+type ExistingProvider struct {
+	kubeconfig  string
+	kubeContext string
+	// path is the kubeconfig used by KEMU. When a context is provided, it is
+	// a temporary self-contained kubeconfig with only that context.
+	path string
+}
+
+func NewExistingProvider(kubeconfig, kubeContext string) (*ExistingProvider, error) {
+	p := &ExistingProvider{kubeconfig: kubeconfig, kubeContext: kubeContext, path: kubeconfig}
+	if len(kubeContext) == 0 {
+		return p, nil
+	}
+
+	path, err := kubeconfigForContext(kubeconfig, kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	p.path = path
+	return p, nil
+}
+
+func (p *ExistingProvider) Name() string { return ProviderExisting }
+
+// Exists reports whether KEMU has already been installed into the cluster.
+func (p *ExistingProvider) Exists() (bool, error) {
+	kubeClient, err := kubeClientFromConfig(p.path)
+	if err != nil {
+		return false, err
+	}
+	record, err := loadClusterRecord(kubeClient)
+	return record != nil, err
+}
+
+// Create only verifies that the control plane is reachable. The Kind config
+// is ignored.
+func (p *ExistingProvider) Create(kindConfig string) error {
+	if len(kindConfig) > 0 {
+		slog.Warn("kindConfig is ignored by the existing control plane provider")
+	}
+	kubeClient, err := kubeClientFromConfig(p.path)
+	if err != nil {
+		return err
+	}
+	serverVersion, err := kubeClient.Discovery().ServerVersion()
+	if err != nil {
+		return fmt.Errorf("existing control plane is not reachable: %w", err)
+	}
+	slog.Info("using existing control plane", "kubeconfig", p.kubeconfig, "context", p.kubeContext, "version", serverVersion.GitVersion)
+	return nil
+}
+
+// LoadImages is not supported as KEMU has no access to the nodes of an
+// existing control plane.
+func (p *ExistingProvider) LoadImages(images api.Images) error {
+	if len(images.Preload) > 0 || len(images.Archives) > 0 {
+		slog.Warn("image preloading is not supported by the existing control plane provider, images must be available on its nodes")
+	}
+	return nil
+}
+
+func (p *ExistingProvider) Connect() error { return nil }
+
+func (p *ExistingProvider) Kubeconfig() string { return p.path }
+
+func (p *ExistingProvider) UpdateStatus(status *api.ClusterStatus) {
+	status.Provider = ProviderExisting
+	status.Kubeconfig = p.kubeconfig
+	if path, err := filepath.Abs(p.kubeconfig); err == nil {
+		status.Kubeconfig = path
+	}
+	status.Context = p.kubeContext
+}
+
+// Delete removes only what KEMU installed: the emulated nodes, the KWOK
+// stages, the Helm releases, and the cluster record. The control plane itself
+// is left intact.
+func (p *ExistingProvider) Delete() error {
+	kubeClient, err := kubeClientFromConfig(p.path)
+	if err != nil {
+		return err
+	}
+	record, err := loadClusterRecord(kubeClient)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("deleting KEMU nodes")
+	err = kubeClient.CoreV1().Nodes().DeleteCollection(context.TODO(), metav1.DeleteOptions{}, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=true", ManagedByKemuLabel),
+	})
+	if err != nil {
+		return err
+	}
+
+	var recorded []api.ClusterAddon
+	if record != nil && record.Status != nil {
+		for _, addon := range record.Status.Addons {
+			recorded = append(recorded, api.ClusterAddon{Name: addon.Name, Namespace: addon.Namespace})
+		}
+	}
+	releases, err := listReleases(kubeClient, p.path, recorded, action.ListAll)
+	if err != nil {
+		return err
+	}
+	// KWOK is uninstalled last so that addons relying on it are removed first.
+	var addons, kwok []api.ClusterAddon
+	for _, key := range slices.Sorted(maps.Keys(releases)) {
+		rel := releases[key]
+		isRecorded := slices.ContainsFunc(recorded, func(a api.ClusterAddon) bool { return a.Namespace == rel.Namespace && a.Name == rel.Name })
+		if rel.Labels[ManagedByKemuLabel] != "true" && !isRecorded {
+			continue
+		}
+		addon := api.ClusterAddon{Name: rel.Name, Namespace: rel.Namespace}
+		if isKWOKAddon(addon) {
+			kwok = append(kwok, addon)
+		} else {
+			addons = append(addons, addon)
+		}
+	}
+	// Stages are deleted while their CRD still exists.
+	if err = deleteKWOKStages(p.path, nil); err != nil {
+		return err
+	}
+	if err = UninstallAddons(append(addons, kwok...), p.path); err != nil {
+		return err
+	}
+
+	slog.Info("deleting cluster config record", "namespace", ClusterRecordNamespace, "name", ClusterRecordName)
+	err = kubeClient.CoreV1().ConfigMaps(ClusterRecordNamespace).Delete(context.TODO(), ClusterRecordName, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Close removes the temporary kubeconfig created for the context.
+func (p *ExistingProvider) Close() error {
+	if p.path == p.kubeconfig {
+		return nil
+	}
+	return os.Remove(p.path)
+}
+
+// kubeconfigForContext writes a self-contained kubeconfig with the context as
+// its only and current context to a temporary file and returns its path.
+func kubeconfigForContext(kubeconfig, kubeContext string) (string, error) {
+	config, err := clientcmd.LoadFromFile(kubeconfig)
+	if err != nil {
+		return "", err
+	}
+	if _, found := config.Contexts[kubeContext]; !found {
+		return "", fmt.Errorf("context %q not found in %s", kubeContext, kubeconfig)
+	}
+	config.CurrentContext = kubeContext
+	if err = clientcmdapi.MinifyConfig(config); err != nil {
+		return "", err
+	}
+	if err = clientcmd.ResolveLocalPaths(config); err != nil {
+		return "", err
+	}
+	if err = clientcmdapi.FlattenConfig(config); err != nil {
+		return "", err
+	}
+
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return "", err
+	}
+	file, err := writeTempFile(string(data))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return file.Name(), nil
+}
@@ -0,0 +1,141 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/datastrophic/kemu/pkg/api"
+	"github.com/datastrophic/kemu/pkg/version"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/action"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	ClusterRecordNamespace = "kube-system"
+	ClusterRecordName      = "kemu-cluster-config"
+
+	clusterRecordKey = "config.yaml"
+)
+
+// GetClusterRecord returns the ClusterConfig stored in the cluster by KEMU
+// along with the bootstrap metadata in its status.
+func GetClusterRecord(kubeconfig string) (api.ClusterConfig, error) {
+	kubeClient, err := kubeClientFromConfig(kubeconfig)
+	if err != nil {
+		return api.ClusterConfig{}, err
+	}
+
+	record, err := loadClusterRecord(kubeClient)
+	if err != nil {
+		return api.ClusterConfig{}, err
+	}
+	if record == nil {
+		return api.ClusterConfig{}, fmt.Errorf("cluster config record %s/%s not found. is it a KEMU cluster?", ClusterRecordNamespace, ClusterRecordName)
+	}
+	return *record, nil
+}
+
+// loadClusterRecord returns the stored ClusterConfig or nil if the cluster has no record.
+func loadClusterRecord(kubeClient kubernetes.Interface) (*api.ClusterConfig, error) {
+	cm, err := kubeClient.CoreV1().ConfigMaps(ClusterRecordNamespace).Get(context.TODO(), ClusterRecordName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record api.ClusterConfig
+	if err = yaml.Unmarshal([]byte(cm.Data[clusterRecordKey]), &record); err != nil {
+		return nil, fmt.Errorf("failed to parse cluster config record: %w", err)
+	}
+	return &record, nil
+}
+
+// saveClusterRecord stores the ClusterConfig and its status in the cluster,
+// keeping the original creation time and completed bootstrap phases when the
+// record already exists.
+func saveClusterRecord(kubeClient kubernetes.Interface, config api.ClusterConfig, status api.ClusterStatus) error {
+	slog.Info("saving cluster config record", "namespace", ClusterRecordNamespace, "name", ClusterRecordName)
+	existing, err := loadClusterRecord(kubeClient)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	status.UpdatedAt = now
+	status.CreatedAt = now
+	if existing != nil && existing.Status != nil {
+		status.CreatedAt = existing.Status.CreatedAt
+		if status.CompletedPhases == nil {
+			status.CompletedPhases = existing.Status.CompletedPhases
+		}
+	}
+	config.Status = &status
+
+	var data bytes.Buffer
+	encoder := yaml.NewEncoder(&data)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(config); err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ClusterRecordName,
+			Namespace: ClusterRecordNamespace,
+			Labels:    map[string]string{ManagedByKemuLabel: "true"},
+		},
+		Data: map[string]string{clusterRecordKey: data.String()},
+	}
+
+	if existing == nil {
+		_, err = kubeClient.CoreV1().ConfigMaps(ClusterRecordNamespace).Create(context.TODO(), cm, metav1.CreateOptions{})
+	} else {
+		_, err = kubeClient.CoreV1().ConfigMaps(ClusterRecordNamespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// clusterStatus collects bootstrap metadata for the cluster record, resolving
+// addon versions from the deployed Helm releases.
+func clusterStatus(kubeClient kubernetes.Interface, config api.ClusterConfig, name, kubeconfig string) (api.ClusterStatus, error) {
+	status := api.ClusterStatus{
+		ClusterName: name,
+		Kubeconfig:  kubeconfig,
+		KemuVersion: version.Get(),
+	}
+	if path, err := filepath.Abs(kubeconfig); err == nil {
+		status.Kubeconfig = path
+	}
+
+	desired := desiredAddons(config)
+	releases, err := listReleases(kubeClient, kubeconfig, desired, action.ListDeployed)
+	if err != nil {
+		return status, err
+	}
+	for _, addon := range desired {
+		addonStatus := api.AddonStatus{
+			Name:      addon.Name,
+			Namespace: addon.Namespace,
+			Chart:     addon.Chart,
+			Version:   addon.Version,
+		}
+		if rel, found := releases[addon.Namespace+"/"+addon.Name]; found && rel.Chart != nil && rel.Chart.Metadata != nil {
+			addonStatus.Version = rel.Chart.Metadata.Version
+		}
+		if addon.Name == kwokControllerRelease && isKWOKAddon(addon) {
+			status.KWOKChartVersion = addonStatus.Version
+		}
+		status.Addons = append(status.Addons, addonStatus)
+	}
+	return status, nil
+}
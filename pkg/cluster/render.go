@@ -0,0 +1,139 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+
+	"github.com/datastrophic/kemu/pkg/api"
+	helmclient "github.com/mittwald/go-helm-client"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	RenderFormatYAML = "yaml"
+	RenderFormatJSON = "json"
+
+	// RenderedPlanName is the name of the ConfigMap holding the rendered
+	// addon chart specs and Kind config.
+	RenderedPlanName = "kemu-bootstrap-plan"
+
+	renderedKindConfigKey = "kind-config.yaml"
+	renderedAddonsKey     = "addons.yaml"
+)
+
+// defaultKindConfig is the configuration Kind uses when none is provided.
+const defaultKindConfig = `kind: Cluster
+apiVersion: kind.x-k8s.io/v1alpha4
+`
+
+// RenderKemuCluster writes everything KEMU would create for the cluster
+// config to w without creating anything: a ConfigMap with the effective Kind
+// config and the Helm chart specs of all addons, followed by the KWOK stages
+// of the profile and the nodes.
+// The output is a multi-document YAML stream or a JSON v1 List, both
+// accepted by kubectl apply.
+func RenderKemuCluster(configPath, format string, w io.Writer) error {
+	if format != RenderFormatYAML && format != RenderFormatJSON {
+		return fmt.Errorf("unsupported output format %q. supported formats: %s, %s", format, RenderFormatYAML, RenderFormatJSON)
+	}
+
+	clusterConfig, err := parseKemuClusterConfig(configPath)
+	if err != nil {
+		return err
+	}
+	objects, err := renderObjects(clusterConfig)
+	if err != nil {
+		return err
+	}
+
+	if format == RenderFormatJSON {
+		return writeJSONList(w, objects)
+	}
+	return writeYAMLDocuments(w, objects)
+}
+
+func renderObjects(clusterConfig api.ClusterConfig) ([]runtime.Object, error) {
+	kindConfig := clusterConfig.Spec.KindConfig
+	if len(kindConfig) == 0 {
+		kindConfig = defaultKindConfig
+	}
+
+	var chartSpecs []*helmclient.ChartSpec
+	for _, addon := range desiredAddons(clusterConfig) {
+		chartSpecs = append(chartSpecs, chartSpecForAddon(addon))
+	}
+	addons, err := yaml.Marshal(chartSpecs)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []runtime.Object{
+		&corev1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      RenderedPlanName,
+				Namespace: ClusterRecordNamespace,
+				Labels:    map[string]string{ManagedByKemuLabel: "true"},
+			},
+			Data: map[string]string{
+				renderedKindConfigKey: kindConfig,
+				renderedAddonsKey:     string(addons),
+			},
+		},
+	}
+	stages, err := kwokStages(clusterConfig.Spec.KWOK)
+	if err != nil {
+		return nil, err
+	}
+	for _, stage := range stages {
+		stage.Labels = maps.Clone(stage.Labels)
+		if stage.Labels == nil {
+			stage.Labels = make(map[string]string)
+		}
+		stage.Labels[ManagedByKemuLabel] = "true"
+		objects = append(objects, &stage)
+	}
+	for _, node := range clusterNodeSpecs(clusterConfig.Spec.NodeGroups) {
+		node.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Node"}
+		objects = append(objects, &node)
+	}
+	return objects, nil
+}
+
+func writeYAMLDocuments(w io.Writer, objects []runtime.Object) error {
+	for i, obj := range objects {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err = io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSONList(w io.Writer, objects []runtime.Object) error {
+	list := corev1.List{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "List"}}
+	for _, obj := range objects {
+		raw, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		list.Items = append(list.Items, runtime.RawExtension{Raw: raw})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(list)
+}
@@ -0,0 +1,276 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/datastrophic/kemu/pkg/api"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DefaultDrainTimeout = 5 * time.Minute
+
+	drainPollInterval = 2 * time.Second
+)
+
+// ScaleOptions configure how a node group is scaled.
+type ScaleOptions struct {
+	// Drain cordons nodes and evicts their pods before the nodes are deleted.
+	Drain bool
+	// DrainTimeout limits how long a single node is drained.
+	DrainTimeout time.Duration
+	// NodeCreation overrides the default node creation settings.
+	NodeCreation api.NodeCreation
+}
+
+// ScaleNodeGroup changes the number of nodes of the node group in the zone.
+// New nodes follow the <group>-<zone>-<i> naming and are created from the
+// node group in the cluster record. When shrinking, the highest-indexed
+// nodes are removed first.
+func ScaleNodeGroup(nodeGroupName, zone string, replicas int, kubeconfig string, options ScaleOptions) error {
+	if replicas < 0 {
+		return fmt.Errorf("replicas must be greater than or equal to 0, got %d", replicas)
+	}
+	slog.Info("scaling node group", "name", nodeGroupName, "zone", zone, "replicas", replicas)
+
+	settings := nodeCreationWithDefaults(options.NodeCreation)
+	kubeClient, err := kubeClientWithRateLimits(kubeconfig, settings.QPS, settings.Burst)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := kubeClient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=true,%s=%s", ManagedByKemuLabel, NodeGroupLabel, nodeGroupName),
+	})
+	if err != nil {
+		return err
+	}
+	if len(nodes.Items) == 0 {
+		return fmt.Errorf("node group %q has no nodes in the cluster. use kemu apply to add new node groups", nodeGroupName)
+	}
+
+	if err = checkZoneWithoutTopology(kubeClient, nodeGroupName, zone); err != nil {
+		return err
+	}
+
+	// Nodes in the zone ordered by their index.
+	indexed := make(map[int]corev1.Node)
+	template, templateInZone := nodes.Items[0], false
+	for _, node := range nodes.Items {
+		if node.Labels[ZoneLabel] != zone {
+			continue
+		}
+		i, err := nodeIndex(node.Name, nodeGroupName, zone)
+		if err != nil {
+			return err
+		}
+		indexed[i] = node
+		template, templateInZone = node, true
+	}
+
+	current := len(indexed)
+	switch {
+	case current < replicas:
+		nodeGroup, placement, err := scaledNodeGroup(kubeClient, nodeGroupName, zone, template, templateInZone)
+		if err != nil {
+			return err
+		}
+		var toCreate []corev1.Node
+		for i := 0; len(indexed)+len(toCreate) < replicas; i++ {
+			if _, found := indexed[i]; !found {
+				toCreate = append(toCreate, createNodeSpec(nodeGroup, placement, i))
+			}
+		}
+		if err = createNodes(toCreate, settings, kubeconfig); err != nil {
+			return err
+		}
+	case current > replicas:
+		indices := slices.Sorted(maps.Keys(indexed))
+		var toDelete []corev1.Node
+		for _, i := range indices[replicas:] {
+			toDelete = append(toDelete, indexed[i])
+		}
+		err = forEachNode(toDelete, settings.Concurrency, "delete", func(node *corev1.Node) error {
+			if options.Drain {
+				timeout := options.DrainTimeout
+				if timeout == 0 {
+					timeout = DefaultDrainTimeout
+				}
+				if err := drainNode(kubeClient, node.Name, timeout); err != nil {
+					return err
+				}
+			}
+			return deleteNode(kubeClient, node.Name)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = recordNodeGroupScale(kubeClient, nodeGroupName, zone, replicas); err != nil {
+		return err
+	}
+
+	slog.Info("node group scaled", "name", nodeGroupName, "zone", zone, "from", current, "to", replicas)
+	return nil
+}
+
+// scaledNodeGroup returns the node group and the placement that new nodes of
+// the zone are created from. The node group comes from the cluster record,
+// so that the labels and topology of other zones don't leak into the zone,
+// with the capacity of the template node when the record lacks it. Clusters
+// without a record model new nodes after the template node.
+func scaledNodeGroup(kubeClient kubernetes.Interface, nodeGroupName, zone string, template corev1.Node, templateInZone bool) (api.NodeGroup, api.Placement, error) {
+	fromTemplate := nodeGroupFromNode(template)
+	placement := api.Placement{AvailabilityZone: zone}
+	if templateInZone {
+		placement.Region = template.Labels[RegionLabel]
+	}
+
+	record, err := loadClusterRecord(kubeClient)
+	if err != nil {
+		return api.NodeGroup{}, api.Placement{}, err
+	}
+	if record == nil {
+		return fromTemplate, placement, nil
+	}
+	record.ApplyInstanceTypes()
+	i := slices.IndexFunc(record.Spec.NodeGroups, func(ng api.NodeGroup) bool { return ng.Name == nodeGroupName })
+	if i < 0 {
+		return fromTemplate, placement, nil
+	}
+
+	nodeGroup := record.Spec.NodeGroups[i]
+	for _, p := range nodeGroup.Placement {
+		if p.AvailabilityZone == zone {
+			placement.Region = p.Region
+		}
+	}
+	if len(nodeGroup.NodeTemplate.Capacity) == 0 {
+		nodeGroup.NodeTemplate.Capacity = fromTemplate.NodeTemplate.Capacity
+	}
+	version, err := controlPlaneVersion(kubeClient)
+	if err != nil {
+		return api.NodeGroup{}, api.Placement{}, err
+	}
+	return withKubeletVersion([]api.NodeGroup{nodeGroup}, version)[0], placement, nil
+}
+
+// recordNodeGroupScale updates the placement of the node group in the cluster
+// record so that it reflects the new number of nodes in the zone.
+func recordNodeGroupScale(kubeClient kubernetes.Interface, nodeGroupName, zone string, replicas int) error {
+	record, err := loadClusterRecord(kubeClient)
+	if err != nil || record == nil || record.Status == nil {
+		return err
+	}
+
+	i := slices.IndexFunc(record.Spec.NodeGroups, func(ng api.NodeGroup) bool { return ng.Name == nodeGroupName })
+	if i < 0 {
+		return nil
+	}
+	nodeGroup := &record.Spec.NodeGroups[i]
+	// Scaling a single zone turns replicas spread across zones into
+	// explicit zone replicas.
+	nodeGroup.Placement = nodeGroup.ZonePlacement()
+	nodeGroup.Replicas = nil
+	j := slices.IndexFunc(nodeGroup.Placement, func(p api.Placement) bool { return p.AvailabilityZone == zone })
+	if j < 0 {
+		nodeGroup.Placement = append(nodeGroup.Placement, api.Placement{AvailabilityZone: zone, Replicas: replicas})
+	} else {
+		nodeGroup.Placement[j].Replicas = replicas
+	}
+	return saveClusterRecord(kubeClient, *record, *record.Status)
+}
+
+// checkZoneWithoutTopology rejects scaling a zone with a network topology in
+// the cluster record, as its nodes are named after their topology domains.
+func checkZoneWithoutTopology(kubeClient kubernetes.Interface, nodeGroupName, zone string) error {
+	record, err := loadClusterRecord(kubeClient)
+	if err != nil || record == nil {
+		return err
+	}
+	for _, nodeGroup := range record.Spec.NodeGroups {
+		if nodeGroup.Name != nodeGroupName {
+			continue
+		}
+		for _, p := range nodeGroup.Placement {
+			if p.AvailabilityZone == zone && p.Topology != nil {
+				return fmt.Errorf("zone %q of node group %q has a network topology. change the replicas in the cluster config and use kemu apply", zone, nodeGroupName)
+			}
+		}
+	}
+	return nil
+}
+
+// nodeIndex extracts the index from a node name generated by nodeName.
+func nodeIndex(name, nodeGroup, zone string) (int, error) {
+	prefix := nodeNamePrefix(nodeGroup, zone)
+	i, err := strconv.Atoi(strings.TrimPrefix(name, prefix))
+	if err != nil || !strings.HasPrefix(name, prefix) {
+		return 0, fmt.Errorf("node %q doesn't follow the %s<i> naming", name, prefix)
+	}
+	return i, nil
+}
+
+// drainNode cordons the node and evicts all pods except DaemonSet-managed
+// ones, waiting for the pods to be gone. Evictions blocked by disruption
+// budgets are retried until the timeout.
+func drainNode(kubeClient kubernetes.Interface, name string, timeout time.Duration) error {
+	slog.Info("draining node", "node", name)
+	patch := []byte(`{"spec":{"unschedulable":true}}`)
+	if _, err := kubeClient.CoreV1().Nodes().Patch(context.TODO(), name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return wait.PollUntilContextCancel(ctx, drainPollInterval, true, func(ctx context.Context) (bool, error) {
+		pods, err := kubeClient.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+			FieldSelector: fmt.Sprintf("spec.nodeName=%s", name),
+		})
+		if err != nil {
+			return false, err
+		}
+
+		remaining := 0
+		for _, pod := range pods.Items {
+			if isDaemonSetPod(pod) || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			remaining++
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			err := kubeClient.PolicyV1().Evictions(pod.Namespace).Evict(ctx, &policyv1.Eviction{
+				ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+			})
+			if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsTooManyRequests(err) {
+				return false, err
+			}
+		}
+		return remaining == 0, nil
+	})
+}
+
+func isDaemonSetPod(pod corev1.Pod) bool {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return true
+		}
+	}
+	return false
+}
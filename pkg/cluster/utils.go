@@ -1,6 +1,8 @@
 package cluster
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -25,6 +27,19 @@ func kubeClientFromConfig(kubeconfig string) (*kubernetes.Clientset, error) {
 	return kubernetes.NewForConfig(config)
 }
 
+// kubeClientWithRateLimits creates a client with the client-side rate limiter
+// configured to the provided QPS and burst instead of client-go defaults.
+func kubeClientWithRateLimits(kubeconfig string, qps float32, burst int) (*kubernetes.Clientset, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	config.QPS = qps
+	config.Burst = burst
+
+	return kubernetes.NewForConfig(config)
+}
+
 func kwokClientFromConfig(kubeconfig string) (*kwokclient.Clientset, error) {
 	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
 	if err != nil {
@@ -68,9 +83,28 @@ func parseKemuClusterConfig(loc string) (api.ClusterConfig, error) {
 	}
 
 	var clusterConfig api.ClusterConfig
+	var errs []error
+
+	decoder := yaml.NewDecoder(bytes.NewReader(body))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&clusterConfig); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return api.ClusterConfig{}, err
+		}
+		// Unknown fields and type mismatches don't stop the decoder, so the
+		// rest of the config is still validated below.
+		for _, e := range typeErr.Errors {
+			errs = append(errs, errors.New(e))
+		}
+	}
 
-	if err := yaml.Unmarshal(body, &clusterConfig); err != nil {
-		return api.ClusterConfig{}, err
+	clusterConfig.ApplyInstanceTypes()
+	if err := clusterConfig.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return api.ClusterConfig{}, fmt.Errorf("invalid cluster config %q:\n%w", loc, errors.Join(errs...))
 	}
 
 	return clusterConfig, nil
@@ -0,0 +1,634 @@
+package report
+
+import (
+	"cmp"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/datastrophic/kemu/pkg/timeline"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	ResourceCPU    = "cpu"
+	ResourceMemory = "memory"
+	ResourceGPU    = "nvidia.com/gpu"
+
+	ScopeCluster   = "cluster"
+	ScopeZone      = "zone"
+	ScopeNodeGroup = "nodeGroup"
+
+	// seriesPoints is the number of samples of the time series.
+	seriesPoints = 200
+)
+
+// Resources are the resources whose allocation is reported.
+var Resources = []string{ResourceCPU, ResourceMemory, ResourceGPU}
+
+// Report summarizes the scheduling of a timeline. Durations are in seconds,
+// CPU in cores, memory in bytes, and GPUs in devices.
+type Report struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	Pods Counts `json:"pods"`
+	Jobs Counts `json:"jobs"`
+	// PodQueueingDelay is the time from creating a Pod to scheduling it.
+	PodQueueingDelay Distribution `json:"podQueueingDelay"`
+	// JobQueueingDelay is the time from creating a job to scheduling all of
+	// its workers, including the time a Job was suspended, e.g. by Kueue.
+	JobQueueingDelay Distribution `json:"jobQueueingDelay"`
+	// JobCompletionTime is the time from creating a job to its completion
+	// or failure.
+	JobCompletionTime Distribution `json:"jobCompletionTime"`
+
+	Allocation       []Allocation  `json:"allocation"`
+	GPUFragmentation Fragmentation `json:"gpuFragmentation"`
+	Tenants          []Tenant      `json:"tenants"`
+	// Fairness is Jain's fairness index of the resource time allocated to
+	// tenants, GPU time if any GPUs were allocated and CPU time otherwise,
+	// from 1/n when a single tenant got everything to 1 for equal shares.
+	// Pods without a tenant, e.g. system Pods, are left out of tenants.
+	Fairness         float64 `json:"fairness"`
+	FairnessResource string  `json:"fairnessResource"`
+
+	Series Series `json:"series"`
+}
+
+// Counts of Pods or jobs by outcome. Jobs are Jobs and standalone Pods, i.e.
+// Pods without a controller or submitted by kemu workload submit or kemu
+// replay, so Pods of Deployments, DaemonSets, or static Pods aren't jobs.
+type Counts struct {
+	Created   int `json:"created"`
+	Scheduled int `json:"scheduled"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Preempted int `json:"preempted"`
+}
+
+// Distribution of durations in seconds.
+type Distribution struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// Allocation is the time-weighted average of a resource allocated to Pods
+// and allocatable on the nodes of a scope: the cluster, a zone, or a node
+// group.
+type Allocation struct {
+	Scope       string  `json:"scope"`
+	Name        string  `json:"name"`
+	Resource    string  `json:"resource"`
+	Allocated   float64 `json:"allocated"`
+	Allocatable float64 `json:"allocatable"`
+	Utilization float64 `json:"utilization"`
+}
+
+// Fragmentation of free GPUs. Free GPUs of partially allocated nodes are
+// fragmented as they can't be used by jobs requesting full nodes. Averages
+// are time-weighted, cordoned nodes are excluded.
+type Fragmentation struct {
+	FreeGPUs       float64 `json:"freeGPUs"`
+	FragmentedGPUs float64 `json:"fragmentedGPUs"`
+	// Ratio is the share of free GPU time that was fragmented.
+	Ratio float64 `json:"ratio"`
+}
+
+// Tenant summarizes the jobs of a tenant. Allocated resources are resource
+// seconds, e.g. GPU seconds.
+type Tenant struct {
+	Name             string             `json:"name"`
+	Jobs             int                `json:"jobs"`
+	Pods             int                `json:"pods"`
+	JobQueueingDelay Distribution       `json:"jobQueueingDelay"`
+	Allocated        map[string]float64 `json:"allocated"`
+	// Share of the resource time allocated to all tenants, per resource.
+	Share map[string]float64 `json:"share"`
+}
+
+// Series are the values of the cluster over time, sampled at Times seconds
+// from the start.
+type Series struct {
+	Times       []float64            `json:"times"`
+	Utilization map[string][]float64 `json:"utilization"`
+	PendingPods []float64            `json:"pendingPods"`
+	FreeGPUs    []float64            `json:"freeGPUs"`
+	Fragmented  []float64            `json:"fragmentedGPUs"`
+}
+
+type scopeKey struct {
+	scope, name, resource string
+}
+
+type integral struct {
+	allocated, allocatable float64 // current values
+	allocatedTime          float64 // integrals over time in resource seconds
+	allocatableTime        float64
+}
+
+type node struct {
+	zone, nodeGroup string
+	allocatable     map[string]float64
+	allocated       map[string]float64
+	cordoned        bool
+}
+
+type pod struct {
+	created, scheduled time.Time
+	node, job, tenant  string
+	requests           map[string]float64
+	done, preempted    bool
+}
+
+type job struct {
+	created, started, finished time.Time
+	workers                    int
+	scheduled                  int
+	tenant                     string
+	pods                       int
+}
+
+type sample struct {
+	time        time.Time
+	utilization map[string]float64
+	pending     float64
+	free        float64
+	fragmented  float64
+}
+
+type builder struct {
+	report    Report
+	last      time.Time
+	nodes     map[string]*node
+	pods      map[string]*pod
+	jobs      map[string]*job
+	integrals map[scopeKey]*integral
+	tenants   map[string]map[string]*integral
+	pending   int
+
+	free, fragmented         float64
+	freeTime, fragmentedTime float64
+
+	podDelays []float64
+	samples   []sample
+}
+
+// Compute builds the report of a timeline sorted by time. The report ends at
+// end, or at the last event when end is zero.
+func Compute(events []timeline.Event, end time.Time) Report {
+	b := &builder{
+		nodes:     make(map[string]*node),
+		pods:      make(map[string]*pod),
+		jobs:      make(map[string]*job),
+		integrals: make(map[scopeKey]*integral),
+		tenants:   make(map[string]map[string]*integral),
+	}
+	if len(events) == 0 {
+		return b.report
+	}
+	b.report.Start = events[0].Time
+	b.last = events[0].Time
+	for _, event := range events {
+		b.advance(event.Time)
+		b.observe(event)
+		b.sample(event.Time)
+	}
+	if end.IsZero() || end.Before(b.last) {
+		end = b.last
+	}
+	b.advance(end)
+	b.sample(end)
+	b.report.End = end
+	b.summarize()
+	return b.report
+}
+
+// advance integrates the current values until t.
+func (b *builder) advance(t time.Time) {
+	dt := t.Sub(b.last).Seconds()
+	if dt <= 0 {
+		return
+	}
+	for _, i := range b.integrals {
+		i.allocatedTime += i.allocated * dt
+		i.allocatableTime += i.allocatable * dt
+	}
+	for _, resources := range b.tenants {
+		for _, i := range resources {
+			i.allocatedTime += i.allocated * dt
+		}
+	}
+	b.freeTime += b.free * dt
+	b.fragmentedTime += b.fragmented * dt
+	b.last = t
+}
+
+func (b *builder) observe(event timeline.Event) {
+	key := event.Namespace + "/" + event.Name
+	switch event.Type {
+	case timeline.NodeAdded:
+		if _, found := b.nodes[event.Name]; found {
+			return
+		}
+		n := &node{zone: event.Zone, nodeGroup: event.NodeGroup, allocated: make(map[string]float64)}
+		b.nodes[event.Name] = n
+		b.updateNode(n, func() {
+			n.allocatable = quantities(event.Resources)
+			b.addToScopes(n, n.allocatable, 1, func(i *integral) *float64 { return &i.allocatable })
+		})
+	case timeline.NodeRemoved:
+		if n, found := b.nodes[event.Name]; found {
+			b.updateNode(n, func() {
+				b.addToScopes(n, n.allocatable, -1, func(i *integral) *float64 { return &i.allocatable })
+				b.addToScopes(n, n.allocated, -1, func(i *integral) *float64 { return &i.allocated })
+				n.allocatable, n.allocated = nil, nil
+			})
+			delete(b.nodes, event.Name)
+		}
+	case timeline.NodeCordoned, timeline.NodeUncordoned:
+		if n, found := b.nodes[event.Name]; found {
+			b.updateNode(n, func() { n.cordoned = event.Type == timeline.NodeCordoned })
+		}
+
+	case timeline.JobCreated:
+		// A Job seen again, e.g. after the recorder restarts, keeps its Pods.
+		j, found := b.jobs[key]
+		if !found {
+			b.report.Jobs.Created++
+			j = &job{created: event.Time}
+			b.jobs[key] = j
+		}
+		j.workers = max(int(event.Workers), 1)
+		if len(event.Tenant) > 0 {
+			j.tenant = event.Tenant
+		}
+	case timeline.JobCompleted, timeline.JobFailed:
+		if j, found := b.jobs[key]; found && j.finished.IsZero() {
+			j.finished = event.Time
+			if event.Type == timeline.JobCompleted {
+				b.report.Jobs.Succeeded++
+			} else {
+				b.report.Jobs.Failed++
+			}
+		}
+
+	case timeline.PodCreated:
+		p := &pod{created: event.Time, requests: quantities(event.Resources), tenant: event.Tenant}
+		b.pods[event.UID] = p
+		b.report.Pods.Created++
+		b.pending++
+		if len(event.Job) > 0 {
+			p.job = event.Namespace + "/" + event.Job
+		} else if standalone(event) {
+			p.job = "pod:" + key
+			b.report.Jobs.Created++
+			b.jobs[p.job] = &job{created: event.Time, workers: 1, tenant: event.Tenant}
+		}
+		if j, found := b.jobs[p.job]; found {
+			j.pods++
+			if len(p.tenant) == 0 {
+				p.tenant = j.tenant
+			}
+		}
+	case timeline.PodScheduled:
+		p, found := b.pods[event.UID]
+		if !found || !p.scheduled.IsZero() || p.done {
+			return
+		}
+		p.scheduled = event.Time
+		p.node = event.Node
+		b.pending--
+		b.report.Pods.Scheduled++
+		b.podDelays = append(b.podDelays, event.Time.Sub(p.created).Seconds())
+		if j, found := b.jobs[p.job]; found {
+			j.scheduled++
+			if j.scheduled == j.workers {
+				j.started = event.Time
+				b.report.Jobs.Scheduled++
+			}
+		}
+		b.allocate(p, 1)
+	case timeline.PodPreempted:
+		if p, found := b.pods[event.UID]; found && !p.preempted {
+			p.preempted = true
+			b.report.Pods.Preempted++
+		}
+	case timeline.PodSucceeded, timeline.PodFailed, timeline.PodDeleted:
+		p, found := b.pods[event.UID]
+		if !found || p.done {
+			return
+		}
+		p.done = true
+		if p.scheduled.IsZero() {
+			b.pending--
+		} else {
+			b.allocate(p, -1)
+		}
+		if event.Type == timeline.PodDeleted {
+			return
+		}
+		if event.Type == timeline.PodSucceeded {
+			b.report.Pods.Succeeded++
+		} else {
+			b.report.Pods.Failed++
+		}
+		// Pods without a Job complete their job.
+		if j, found := b.jobs[p.job]; found && strings.HasPrefix(p.job, "pod:") {
+			j.finished = event.Time
+			if event.Type == timeline.PodSucceeded {
+				b.report.Jobs.Succeeded++
+			} else {
+				b.report.Jobs.Failed++
+			}
+		}
+	}
+}
+
+// standalone reports whether a Pod not owned by a Job is a job on its own.
+func standalone(event timeline.Event) bool {
+	return len(event.Controller) == 0 || len(event.JobID) > 0 || len(event.TraceID) > 0
+}
+
+// allocate adds or removes the requests of a Pod on its node and tenant.
+// Pods without a tenant, e.g. system Pods, are only allocated on nodes.
+func (b *builder) allocate(p *pod, sign float64) {
+	if len(p.tenant) > 0 {
+		resources := b.tenants[p.tenant]
+		if resources == nil {
+			resources = make(map[string]*integral)
+			b.tenants[p.tenant] = resources
+		}
+		for name, value := range p.requests {
+			i := resources[name]
+			if i == nil {
+				i = &integral{}
+				resources[name] = i
+			}
+			i.allocated += sign * value
+		}
+	}
+
+	n, found := b.nodes[p.node]
+	if !found {
+		return
+	}
+	b.updateNode(n, func() {
+		for name, value := range p.requests {
+			n.allocated[name] += sign * value
+		}
+		b.addToScopes(n, p.requests, sign, func(i *integral) *float64 { return &i.allocated })
+	})
+}
+
+// updateNode applies a change to a node keeping the free and fragmented GPUs
+// up to date.
+func (b *builder) updateNode(n *node, change func()) {
+	free, fragmented := n.freeGPUs()
+	b.free -= free
+	b.fragmented -= fragmented
+	change()
+	free, fragmented = n.freeGPUs()
+	b.free += free
+	b.fragmented += fragmented
+}
+
+func (n *node) freeGPUs() (float64, float64) {
+	gpus := n.allocatable[ResourceGPU]
+	if gpus <= 0 || n.cordoned {
+		return 0, 0
+	}
+	free := max(gpus-n.allocated[ResourceGPU], 0)
+	if free < gpus {
+		return free, free
+	}
+	return free, 0
+}
+
+func (b *builder) addToScopes(n *node, resources map[string]float64, sign float64, field func(*integral) *float64) {
+	for _, scope := range []scopeKey{{scope: ScopeCluster}, {scope: ScopeZone, name: n.zone}, {scope: ScopeNodeGroup, name: n.nodeGroup}} {
+		if scope.scope != ScopeCluster && len(scope.name) == 0 {
+			continue
+		}
+		for _, name := range Resources {
+			value, found := resources[name]
+			if !found {
+				continue
+			}
+			scope.resource = name
+			i := b.integrals[scope]
+			if i == nil {
+				i = &integral{}
+				b.integrals[scope] = i
+			}
+			*field(i) += sign * value
+		}
+	}
+}
+
+func (b *builder) sample(t time.Time) {
+	s := sample{time: t, utilization: make(map[string]float64), pending: float64(b.pending), free: b.free, fragmented: b.fragmented}
+	for _, name := range Resources {
+		if i := b.integrals[scopeKey{scope: ScopeCluster, resource: name}]; i != nil && i.allocatable > 0 {
+			s.utilization[name] = i.allocated / i.allocatable
+		}
+	}
+	// Only the last sample of a moment is kept.
+	if len(b.samples) > 0 && b.samples[len(b.samples)-1].time.Equal(t) {
+		b.samples[len(b.samples)-1] = s
+		return
+	}
+	b.samples = append(b.samples, s)
+}
+
+func (b *builder) summarize() {
+	r := &b.report
+	r.PodQueueingDelay = distribution(b.podDelays)
+
+	var jobDelays, completionTimes []float64
+	tenantDelays := make(map[string][]float64)
+	tenantJobs := make(map[string]int)
+	tenantPods := make(map[string]int)
+	for _, j := range b.jobs {
+		if len(j.tenant) > 0 {
+			tenantJobs[j.tenant]++
+			tenantPods[j.tenant] += j.pods
+		}
+		if !j.started.IsZero() {
+			delay := j.started.Sub(j.created).Seconds()
+			jobDelays = append(jobDelays, delay)
+			if len(j.tenant) > 0 {
+				tenantDelays[j.tenant] = append(tenantDelays[j.tenant], delay)
+			}
+		}
+		if !j.finished.IsZero() {
+			completionTimes = append(completionTimes, j.finished.Sub(j.created).Seconds())
+		}
+	}
+	r.JobQueueingDelay = distribution(jobDelays)
+	r.JobCompletionTime = distribution(completionTimes)
+
+	keys := slices.SortedFunc(maps.Keys(b.integrals), func(a, b scopeKey) int {
+		return cmp.Or(cmp.Compare(scopeOrder(a.scope), scopeOrder(b.scope)), cmp.Compare(a.name, b.name),
+			cmp.Compare(slices.Index(Resources, a.resource), slices.Index(Resources, b.resource)))
+	})
+	seconds := r.End.Sub(r.Start).Seconds()
+	for _, key := range keys {
+		i := b.integrals[key]
+		allocation := Allocation{Scope: key.scope, Name: key.name, Resource: key.resource}
+		if seconds > 0 {
+			allocation.Allocated = i.allocatedTime / seconds
+			allocation.Allocatable = i.allocatableTime / seconds
+		}
+		if i.allocatableTime > 0 {
+			allocation.Utilization = i.allocatedTime / i.allocatableTime
+		}
+		r.Allocation = append(r.Allocation, allocation)
+	}
+
+	if seconds > 0 {
+		r.GPUFragmentation.FreeGPUs = b.freeTime / seconds
+		r.GPUFragmentation.FragmentedGPUs = b.fragmentedTime / seconds
+	}
+	if b.freeTime > 0 {
+		r.GPUFragmentation.Ratio = b.fragmentedTime / b.freeTime
+	}
+
+	// Tenants are the tenants of jobs and of the Pods allocated in them.
+	names := slices.Collect(maps.Keys(tenantJobs))
+	totals := make(map[string]float64)
+	for tenant, resources := range b.tenants {
+		if !slices.Contains(names, tenant) {
+			names = append(names, tenant)
+		}
+		for name, i := range resources {
+			totals[name] += i.allocatedTime
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		tenant := Tenant{
+			Name:             name,
+			Jobs:             tenantJobs[name],
+			Pods:             tenantPods[name],
+			JobQueueingDelay: distribution(tenantDelays[name]),
+			Allocated:        make(map[string]float64),
+			Share:            make(map[string]float64),
+		}
+		for resourceName, i := range b.tenants[name] {
+			tenant.Allocated[resourceName] = i.allocatedTime
+			if totals[resourceName] > 0 {
+				tenant.Share[resourceName] = i.allocatedTime / totals[resourceName]
+			}
+		}
+		r.Tenants = append(r.Tenants, tenant)
+	}
+	r.FairnessResource = ResourceCPU
+	if totals[ResourceGPU] > 0 {
+		r.FairnessResource = ResourceGPU
+	}
+	var allocated []float64
+	for _, tenant := range r.Tenants {
+		allocated = append(allocated, tenant.Allocated[r.FairnessResource])
+	}
+	r.Fairness = jainIndex(allocated)
+
+	r.Series = b.series()
+}
+
+// series samples the step functions of the cluster at evenly spaced times.
+func (b *builder) series() Series {
+	series := Series{Utilization: make(map[string][]float64)}
+	seconds := b.report.End.Sub(b.report.Start).Seconds()
+	points := seriesPoints
+	if seconds <= 0 {
+		points = 1
+	}
+	next := 0
+	var current sample
+	for p := range points {
+		offset := 0.0
+		if points > 1 {
+			offset = seconds * float64(p) / float64(points-1)
+		}
+		t := b.report.Start.Add(time.Duration(offset * float64(time.Second)))
+		for next < len(b.samples) && !b.samples[next].time.After(t) {
+			current = b.samples[next]
+			next++
+		}
+		series.Times = append(series.Times, offset)
+		series.PendingPods = append(series.PendingPods, current.pending)
+		series.FreeGPUs = append(series.FreeGPUs, current.free)
+		series.Fragmented = append(series.Fragmented, current.fragmented)
+		for _, name := range Resources {
+			series.Utilization[name] = append(series.Utilization[name], current.utilization[name])
+		}
+	}
+	return series
+}
+
+func scopeOrder(scope string) int {
+	return slices.Index([]string{ScopeCluster, ScopeZone, ScopeNodeGroup}, scope)
+}
+
+// distribution returns the mean, maximum, and percentiles interpolated
+// between the closest ranks.
+func distribution(values []float64) Distribution {
+	if len(values) == 0 {
+		return Distribution{}
+	}
+	sorted := slices.Clone(values)
+	sort.Float64s(sorted)
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	return Distribution{
+		Count: len(sorted),
+		Mean:  sum / float64(len(sorted)),
+		P50:   percentile(sorted, 0.5),
+		P90:   percentile(sorted, 0.9),
+		P95:   percentile(sorted, 0.95),
+		P99:   percentile(sorted, 0.99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	rank := p * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+func jainIndex(values []float64) float64 {
+	sum, squares := 0.0, 0.0
+	for _, v := range values {
+		sum += v
+		squares += v * v
+	}
+	if squares == 0 {
+		return 0
+	}
+	return sum * sum / (float64(len(values)) * squares)
+}
+
+// quantities parses the resources of an event into numbers, e.g. cores and
+// bytes, skipping the ones that can't be parsed.
+func quantities(resources map[string]string) map[string]float64 {
+	out := make(map[string]float64)
+	for name, value := range resources {
+		if quantity, err := resource.ParseQuantity(value); err == nil {
+			out[name] = quantity.AsApproximateFloat64()
+		}
+	}
+	return out
+}
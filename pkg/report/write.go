@@ -0,0 +1,236 @@
+package report
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	JSONFile = "report.json"
+	CSVFile  = "report.csv"
+	HTMLFile = "report.html"
+)
+
+//go:embed report.html.tmpl
+var htmlTemplate string
+
+// Write writes the report as JSON, CSV, and HTML files to a directory.
+func Write(report Report, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, write := range map[string]func(Report, io.Writer) error{
+		JSONFile: WriteJSON,
+		CSVFile:  WriteCSV,
+		HTMLFile: WriteHTML,
+	} {
+		if err := writeFile(filepath.Join(dir, name), report, write); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, report Report, write func(Report, io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(report, file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// WriteJSON writes the report as indented JSON.
+func WriteJSON(report Report, out io.Writer) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// WriteCSV writes the summary of the report as section,name,metric,value
+// rows, leaving out the time series.
+func WriteCSV(report Report, out io.Writer) error {
+	writer := csv.NewWriter(out)
+	row := func(section, name, metric string, value float64) {
+		_ = writer.Write([]string{section, name, metric, strconv.FormatFloat(value, 'f', -1, 64)})
+	}
+	distribution := func(section, name string, d Distribution) {
+		row(section, name, "count", float64(d.Count))
+		row(section, name, "mean", d.Mean)
+		row(section, name, "p50", d.P50)
+		row(section, name, "p90", d.P90)
+		row(section, name, "p95", d.P95)
+		row(section, name, "p99", d.P99)
+		row(section, name, "max", d.Max)
+	}
+	counts := func(section string, c Counts) {
+		row(section, "", "created", float64(c.Created))
+		row(section, "", "scheduled", float64(c.Scheduled))
+		row(section, "", "succeeded", float64(c.Succeeded))
+		row(section, "", "failed", float64(c.Failed))
+		row(section, "", "preempted", float64(c.Preempted))
+	}
+
+	_ = writer.Write([]string{"section", "name", "metric", "value"})
+	row("report", "", "durationSeconds", report.End.Sub(report.Start).Seconds())
+	counts("pods", report.Pods)
+	counts("jobs", report.Jobs)
+	distribution("podQueueingDelay", "", report.PodQueueingDelay)
+	distribution("jobQueueingDelay", "", report.JobQueueingDelay)
+	distribution("jobCompletionTime", "", report.JobCompletionTime)
+	for _, a := range report.Allocation {
+		name := a.Scope
+		if len(a.Name) > 0 {
+			name += ":" + a.Name
+		}
+		row("allocation", name, a.Resource+".allocated", a.Allocated)
+		row("allocation", name, a.Resource+".allocatable", a.Allocatable)
+		row("allocation", name, a.Resource+".utilization", a.Utilization)
+	}
+	row("gpuFragmentation", "", "freeGPUs", report.GPUFragmentation.FreeGPUs)
+	row("gpuFragmentation", "", "fragmentedGPUs", report.GPUFragmentation.FragmentedGPUs)
+	row("gpuFragmentation", "", "ratio", report.GPUFragmentation.Ratio)
+	for _, t := range report.Tenants {
+		row("tenants", t.Name, "jobs", float64(t.Jobs))
+		row("tenants", t.Name, "pods", float64(t.Pods))
+		row("tenants", t.Name, "jobQueueingDelay.p50", t.JobQueueingDelay.P50)
+		row("tenants", t.Name, "jobQueueingDelay.p95", t.JobQueueingDelay.P95)
+		for _, resource := range slices.Sorted(maps.Keys(t.Allocated)) {
+			row("tenants", t.Name, resource+".allocatedSeconds", t.Allocated[resource])
+			row("tenants", t.Name, resource+".share", t.Share[resource])
+		}
+	}
+	row("fairness", report.FairnessResource, "jainIndex", report.Fairness)
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteHTML writes the report as a self-contained HTML page with SVG charts.
+func WriteHTML(report Report, out io.Writer) error {
+	t, err := template.New("report").Funcs(template.FuncMap{
+		"number":  formatNumber,
+		"percent": func(v float64) string { return strconv.FormatFloat(v*100, 'f', 1, 64) + "%" },
+		"seconds": formatSeconds,
+		"list":    func(values ...any) []any { return values },
+		"scope": func(a Allocation) string {
+			if len(a.Name) == 0 {
+				return a.Scope
+			}
+			return a.Scope + ": " + a.Name
+		},
+	}).Parse(htmlTemplate)
+	if err != nil {
+		return err
+	}
+
+	series := report.Series
+	utilization := chart{Title: "Cluster allocation", Unit: "%", Max: 100}
+	for i, resource := range Resources {
+		values := make([]float64, len(series.Utilization[resource]))
+		for j, v := range series.Utilization[resource] {
+			values[j] = v * 100
+		}
+		utilization.add(resource, chartColors[i], series.Times, values)
+	}
+	pending := chart{Title: "Pending Pods", Unit: "Pods"}
+	pending.add("pending", chartColors[0], series.Times, series.PendingPods)
+	fragmentation := chart{Title: "Free GPUs", Unit: "GPUs"}
+	fragmentation.add("free", chartColors[1], series.Times, series.FreeGPUs)
+	fragmentation.add("fragmented", chartColors[2], series.Times, series.Fragmented)
+
+	return t.Execute(out, struct {
+		Report
+		Charts []chart
+	}{
+		Report: report,
+		Charts: []chart{utilization.layout(), pending.layout(), fragmentation.layout()},
+	})
+}
+
+var chartColors = []string{"#4e79a7", "#f28e2b", "#e15759"}
+
+const (
+	chartWidth  = 640
+	chartHeight = 200
+	chartMargin = 40
+)
+
+type chart struct {
+	Title  string
+	Unit   string
+	Max    float64
+	Length float64
+	Lines  []line
+	// Ticks are the labels of the Y axis from the bottom.
+	Ticks []tick
+}
+
+type line struct {
+	Name   string
+	Color  string
+	Points string
+	times  []float64
+	values []float64
+}
+
+type tick struct {
+	Y     float64
+	Label string
+}
+
+func (c *chart) add(name, color string, times, values []float64) {
+	c.Lines = append(c.Lines, line{Name: name, Color: color, times: times, values: values})
+	for _, v := range values {
+		c.Max = max(c.Max, v)
+	}
+	if len(times) > 0 {
+		c.Length = max(c.Length, times[len(times)-1])
+	}
+}
+
+// layout scales the lines to the plot area as step functions.
+func (c chart) layout() chart {
+	if c.Max <= 0 {
+		c.Max = 1
+	}
+	length := max(c.Length, 1)
+	x := func(t float64) float64 { return chartMargin + t/length*(chartWidth-chartMargin) }
+	y := func(v float64) float64 { return chartHeight - v/c.Max*(chartHeight-chartMargin/2) }
+	for i, l := range c.Lines {
+		var points []string
+		for j, v := range l.values {
+			if j > 0 {
+				points = append(points, fmt.Sprintf("%.1f,%.1f", x(l.times[j]), y(l.values[j-1])))
+			}
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x(l.times[j]), y(v)))
+		}
+		c.Lines[i].Points = strings.Join(points, " ")
+	}
+	for _, fraction := range []float64{0, 0.5, 1} {
+		c.Ticks = append(c.Ticks, tick{Y: y(c.Max * fraction), Label: formatNumber(c.Max * fraction)})
+	}
+	return c
+}
+
+func formatNumber(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 2, 64)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+func formatSeconds(v float64) string {
+	return strconv.FormatFloat(v, 'f', 1, 64) + "s"
+}
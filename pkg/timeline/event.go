@@ -0,0 +1,126 @@
+package timeline
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// EventType is the kind of a transition in the timeline.
+type EventType string
+
+const (
+	PodCreated       EventType = "PodCreated"
+	PodUnschedulable EventType = "PodUnschedulable"
+	PodScheduled     EventType = "PodScheduled"
+	PodRunning       EventType = "PodRunning"
+	PodSucceeded     EventType = "PodSucceeded"
+	PodFailed        EventType = "PodFailed"
+	PodPreempted     EventType = "PodPreempted"
+	PodDeleted       EventType = "PodDeleted"
+
+	JobCreated   EventType = "JobCreated"
+	JobSuspended EventType = "JobSuspended"
+	JobResumed   EventType = "JobResumed"
+	JobCompleted EventType = "JobCompleted"
+	JobFailed    EventType = "JobFailed"
+	JobDeleted   EventType = "JobDeleted"
+
+	NodeAdded      EventType = "NodeAdded"
+	NodeCordoned   EventType = "NodeCordoned"
+	NodeUncordoned EventType = "NodeUncordoned"
+	NodeRemoved    EventType = "NodeRemoved"
+)
+
+// Event is a transition of a Pod, Job, or Node. Timelines are written as
+// JSON lines with an event per line.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      EventType `json:"type"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name"`
+	UID       string    `json:"uid,omitempty"`
+	// Job is the name of the Job owning a Pod, and Controller is the kind of
+	// any other controller of a Pod, e.g. ReplicaSet or Node for static Pods.
+	Job        string `json:"job,omitempty"`
+	Controller string `json:"controller,omitempty"`
+	// JobID, TraceID, Tenant, and Queue come from the labels set by kemu
+	// workload submit and kemu replay, or by Kueue for the queue.
+	JobID   string `json:"jobId,omitempty"`
+	TraceID string `json:"traceId,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+	Queue   string `json:"queue,omitempty"`
+	// Node, Zone, InstanceType, and NodeGroup describe the node of scheduled
+	// Pods and of Node events.
+	Node         string `json:"node,omitempty"`
+	Zone         string `json:"zone,omitempty"`
+	InstanceType string `json:"instanceType,omitempty"`
+	NodeGroup    string `json:"nodeGroup,omitempty"`
+	// Workers is the parallelism of a Job.
+	Workers int32 `json:"workers,omitempty"`
+	// Resources are the requests of a created Pod, the requests of every Pod
+	// of a created Job, and the allocatable resources of an added Node.
+	Resources map[string]string `json:"resources,omitempty"`
+	Reason    string            `json:"reason,omitempty"`
+	Message   string            `json:"message,omitempty"`
+}
+
+// Read reads a timeline written by the recorder, sorted by time.
+func Read(path string) ([]Event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	events, err := Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read timeline %q: %w", path, err)
+	}
+	return events, nil
+}
+
+// Decode reads JSON lines of events, sorted by time. Events of the same time
+// are ordered so that Nodes are added before Jobs are created, Jobs before
+// their Pods, and Pods before any other transition, since recorded timestamps
+// only have a precision of seconds.
+func Decode(in io.Reader) ([]Event, error) {
+	var events []Event
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(events, func(a, b Event) int {
+		return cmp.Or(a.Time.Compare(b.Time), cmp.Compare(creationOrder(a.Type), creationOrder(b.Type)))
+	})
+	return events, nil
+}
+
+// creationOrder ranks the creation of the objects other events refer to.
+func creationOrder(eventType EventType) int {
+	switch eventType {
+	case NodeAdded:
+		return 0
+	case JobCreated:
+		return 1
+	case PodCreated:
+		return 2
+	default:
+		return 3
+	}
+}
@@ -0,0 +1,556 @@
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/datastrophic/kemu/pkg/cluster"
+	"github.com/datastrophic/kemu/pkg/workload"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// KueueQueueLabel is used for the queue of Pods and Jobs that don't have
+	// the queue label of kemu replay.
+	KueueQueueLabel = "kueue.x-k8s.io/queue-name"
+
+	// Reasons of scheduler events.
+	failedSchedulingReason = "FailedScheduling"
+	preemptedReason        = "Preempted"
+)
+
+// RecordOptions configure which objects are recorded.
+type RecordOptions struct {
+	Kubeconfig string
+	// Namespace of the recorded Pods and Jobs, all namespaces when empty.
+	Namespace string
+}
+
+type podState struct {
+	pod           *corev1.Pod
+	scheduled     bool
+	running       bool
+	finished      bool
+	preempted     bool
+	unschedulable string
+}
+
+type jobState struct {
+	suspended bool
+	finished  bool
+}
+
+type recorder struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	err     error
+	nodes   cache.Store
+	pods    map[types.UID]*podState
+	jobs    map[types.UID]*jobState
+}
+
+// Record watches the Pods, Jobs, KEMU-managed Nodes, and scheduler Events
+// of a cluster and writes their transitions to out as JSON lines until the
+// context is done. Objects existing when the recording starts are recorded
+// with the times of their past transitions taken from the objects, and
+// later transitions with the time they were observed.
+func Record(ctx context.Context, options RecordOptions, out io.Writer) error {
+	return record(ctx, options, out, false)
+}
+
+// Snapshot returns the timeline of the objects existing in the cluster, as
+// recorded when a recording starts. Deleted objects and events aren't
+// included, and times have the precision of the object timestamps.
+func Snapshot(options RecordOptions) ([]Event, error) {
+	var out bytes.Buffer
+	if err := record(context.Background(), options, &out, true); err != nil {
+		return nil, err
+	}
+	return Decode(&out)
+}
+
+func record(ctx context.Context, options RecordOptions, out io.Writer, snapshot bool) error {
+	config, err := clientcmd.BuildConfigFromFlags("", options.Kubeconfig)
+	if err != nil {
+		return err
+	}
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	// Informers retry forever, so connection problems are reported upfront.
+	if _, err = kubeClient.Discovery().ServerVersion(); err != nil {
+		return fmt.Errorf("failed to connect to the cluster: %w", err)
+	}
+
+	r := &recorder{
+		encoder: json.NewEncoder(out),
+		pods:    make(map[types.UID]*podState),
+		jobs:    make(map[types.UID]*jobState),
+	}
+	nodeFactory := informers.NewSharedInformerFactoryWithOptions(kubeClient, 0,
+		informers.WithTweakListOptions(func(listOptions *metav1.ListOptions) {
+			listOptions.LabelSelector = cluster.ManagedByKemuLabel + "=true"
+		}))
+	factory := informers.NewSharedInformerFactoryWithOptions(kubeClient, 0, informers.WithNamespace(options.Namespace))
+	defer nodeFactory.Shutdown()
+	defer factory.Shutdown()
+	// Informers stop before the factories are shut down.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Nodes are synced first, so that scheduled Pods can be described by
+	// the labels of their nodes.
+	nodeInformer := nodeFactory.Core().V1().Nodes().Informer()
+	r.nodes = nodeInformer.GetStore()
+	nodeRegistration, err := nodeInformer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc:    func(obj any, initial bool) { r.observeNode(nil, obj.(*corev1.Node), initial) },
+		UpdateFunc: func(old, obj any) { r.observeNode(old.(*corev1.Node), obj.(*corev1.Node), false) },
+		DeleteFunc: func(obj any) {
+			if node, ok := deletedObject[*corev1.Node](obj); ok {
+				r.emit(nodeEvent(NodeRemoved, node, time.Now().UTC()))
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+	nodeFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), nodeRegistration.HasSynced) {
+		return fmt.Errorf("failed to sync nodes: %w", ctx.Err())
+	}
+
+	podInformer := factory.Core().V1().Pods().Informer()
+	jobInformer := factory.Batch().V1().Jobs().Informer()
+	eventInformer := factory.Core().V1().Events().Informer()
+	handlers := map[cache.SharedIndexInformer]cache.ResourceEventHandler{
+		podInformer: cache.ResourceEventHandlerDetailedFuncs{
+			AddFunc:    func(obj any, initial bool) { r.observePod(obj.(*corev1.Pod), initial) },
+			UpdateFunc: func(_, obj any) { r.observePod(obj.(*corev1.Pod), false) },
+			DeleteFunc: func(obj any) {
+				if pod, ok := deletedObject[*corev1.Pod](obj); ok {
+					r.deletePod(pod)
+				}
+			},
+		},
+		jobInformer: cache.ResourceEventHandlerDetailedFuncs{
+			AddFunc:    func(obj any, initial bool) { r.observeJob(obj.(*batchv1.Job), initial) },
+			UpdateFunc: func(_, obj any) { r.observeJob(obj.(*batchv1.Job), false) },
+			DeleteFunc: func(obj any) {
+				if job, ok := deletedObject[*batchv1.Job](obj); ok {
+					r.deleteJob(job)
+				}
+			},
+		},
+		// Past events are covered by the state of the Pods.
+		eventInformer: cache.ResourceEventHandlerDetailedFuncs{
+			AddFunc: func(obj any, initial bool) {
+				if !initial {
+					r.observeEvent(obj.(*corev1.Event))
+				}
+			},
+			UpdateFunc: func(_, obj any) { r.observeEvent(obj.(*corev1.Event)) },
+		},
+	}
+	// Handlers are synced once they processed the existing objects.
+	var synced []cache.InformerSynced
+	for informer, handler := range handlers {
+		registration, err := informer.AddEventHandler(handler)
+		if err != nil {
+			return err
+		}
+		synced = append(synced, registration.HasSynced)
+	}
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("failed to sync pods, jobs, and events: %w", ctx.Err())
+	}
+	if snapshot {
+		cancel()
+	} else {
+		slog.Info("recording timeline", "nodes", len(r.nodes.ListKeys()), "pods", len(podInformer.GetStore().ListKeys()))
+	}
+
+	<-ctx.Done()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *recorder) emit(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.emitLocked(event)
+}
+
+func (r *recorder) emitLocked(event Event) {
+	if r.err == nil {
+		r.err = r.encoder.Encode(event)
+	}
+}
+
+func (r *recorder) observeNode(old, node *corev1.Node, initial bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old == nil {
+		event := nodeEvent(NodeAdded, node, at(node.CreationTimestamp, initial))
+		allocatable := node.Status.Allocatable
+		if len(allocatable) == 0 {
+			allocatable = node.Status.Capacity
+		}
+		event.Resources = resourceMap(allocatable)
+		r.emitLocked(event)
+	}
+	if node.Spec.Unschedulable && (old == nil || !old.Spec.Unschedulable) {
+		r.emitLocked(nodeEvent(NodeCordoned, node, at(cordonTime(node), initial)))
+	}
+	if !node.Spec.Unschedulable && old != nil && old.Spec.Unschedulable {
+		r.emitLocked(nodeEvent(NodeUncordoned, node, time.Now().UTC()))
+	}
+}
+
+func (r *recorder) observePod(pod *corev1.Pod, initial bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, found := r.pods[pod.UID]
+	if !found {
+		state = &podState{}
+		r.pods[pod.UID] = state
+		event := podEvent(PodCreated, pod, at(pod.CreationTimestamp, initial))
+		event.Resources = resourceMap(podRequests(pod.Spec))
+		r.emitLocked(event)
+	}
+	state.pod = pod
+
+	scheduled := podCondition(pod.Status.Conditions, corev1.PodScheduled)
+	if !state.scheduled && scheduled != nil && scheduled.Status == corev1.ConditionFalse &&
+		scheduled.Reason == corev1.PodReasonUnschedulable && scheduled.Message != state.unschedulable {
+		state.unschedulable = scheduled.Message
+		event := podEvent(PodUnschedulable, pod, at(scheduled.LastTransitionTime, initial))
+		event.Reason, event.Message = scheduled.Reason, scheduled.Message
+		r.emitLocked(event)
+	}
+	if !state.scheduled && len(pod.Spec.NodeName) > 0 {
+		state.scheduled = true
+		var scheduledAt metav1.Time
+		if scheduled != nil {
+			scheduledAt = scheduled.LastTransitionTime
+		}
+		r.emitLocked(r.withNode(podEvent(PodScheduled, pod, at(scheduledAt, initial)), pod.Spec.NodeName))
+	}
+	if !state.running && pod.Status.Phase == corev1.PodRunning {
+		state.running = true
+		r.emitLocked(r.withNode(podEvent(PodRunning, pod, at(podStartTime(pod), initial)), pod.Spec.NodeName))
+	}
+	if !state.finished && (pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed) {
+		state.finished = true
+		eventType := PodSucceeded
+		if pod.Status.Phase == corev1.PodFailed {
+			eventType = PodFailed
+		}
+		event := r.withNode(podEvent(eventType, pod, at(podFinishTime(pod), initial)), pod.Spec.NodeName)
+		event.Reason, event.Message = pod.Status.Reason, pod.Status.Message
+		r.emitLocked(event)
+	}
+	if disruption := podCondition(pod.Status.Conditions, corev1.DisruptionTarget); !state.preempted && disruption != nil &&
+		disruption.Status == corev1.ConditionTrue && disruption.Reason == corev1.PodReasonPreemptionByScheduler {
+		state.preempted = true
+		event := r.withNode(podEvent(PodPreempted, pod, at(disruption.LastTransitionTime, initial)), pod.Spec.NodeName)
+		event.Reason, event.Message = disruption.Reason, disruption.Message
+		r.emitLocked(event)
+	}
+}
+
+func (r *recorder) deletePod(pod *corev1.Pod) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pods, pod.UID)
+	r.emitLocked(r.withNode(podEvent(PodDeleted, pod, time.Now().UTC()), pod.Spec.NodeName))
+}
+
+// observeEvent records scheduling failures and preemptions reported by
+// schedulers, which may not be reflected in the Pod conditions.
+func (r *recorder) observeEvent(event *corev1.Event) {
+	if event.InvolvedObject.Kind != "Pod" || (event.Reason != failedSchedulingReason && event.Reason != preemptedReason) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, found := r.pods[event.InvolvedObject.UID]
+	if !found || state.scheduled && event.Reason == failedSchedulingReason {
+		return
+	}
+
+	var recorded Event
+	switch {
+	case event.Reason == failedSchedulingReason && event.Message != state.unschedulable:
+		state.unschedulable = event.Message
+		recorded = podEvent(PodUnschedulable, state.pod, time.Now().UTC())
+	case event.Reason == preemptedReason && !state.preempted:
+		state.preempted = true
+		recorded = r.withNode(podEvent(PodPreempted, state.pod, time.Now().UTC()), state.pod.Spec.NodeName)
+	default:
+		return
+	}
+	recorded.Reason, recorded.Message = event.Reason, event.Message
+	r.emitLocked(recorded)
+}
+
+func (r *recorder) observeJob(job *batchv1.Job, initial bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	suspended := job.Spec.Suspend != nil && *job.Spec.Suspend
+	state, found := r.jobs[job.UID]
+	if !found {
+		state = &jobState{}
+		r.jobs[job.UID] = state
+		event := jobEvent(JobCreated, job, at(job.CreationTimestamp, initial))
+		event.Workers = jobWorkers(job.Spec)
+		event.Resources = resourceMap(podRequests(job.Spec.Template.Spec))
+		r.emitLocked(event)
+	}
+
+	if suspended != state.suspended {
+		state.suspended = suspended
+		eventType := JobResumed
+		if suspended {
+			eventType = JobSuspended
+		}
+		var transitionTime metav1.Time
+		if condition := jobCondition(job.Status.Conditions, batchv1.JobSuspended); condition != nil {
+			transitionTime = condition.LastTransitionTime
+		}
+		r.emitLocked(jobEvent(eventType, job, at(transitionTime, initial)))
+	}
+	for conditionType, eventType := range map[batchv1.JobConditionType]EventType{batchv1.JobComplete: JobCompleted, batchv1.JobFailed: JobFailed} {
+		condition := jobCondition(job.Status.Conditions, conditionType)
+		if state.finished || condition == nil || condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		state.finished = true
+		event := jobEvent(eventType, job, at(condition.LastTransitionTime, initial))
+		event.Reason, event.Message = condition.Reason, condition.Message
+		r.emitLocked(event)
+	}
+}
+
+// jobWorkers returns the number of Pods of a Job running at once, which is
+// bounded by the completions of the Job.
+func jobWorkers(spec batchv1.JobSpec) int32 {
+	workers := int32(1)
+	if spec.Parallelism != nil {
+		workers = *spec.Parallelism
+	}
+	if spec.Completions != nil {
+		workers = min(workers, *spec.Completions)
+	}
+	return workers
+}
+
+// cordonTime returns when the node was cordoned, taken from the
+// unschedulable taint the node lifecycle controller adds, or the creation of
+// the node when the taint has no time.
+func cordonTime(node *corev1.Node) metav1.Time {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == corev1.TaintNodeUnschedulable && taint.TimeAdded != nil {
+			return *taint.TimeAdded
+		}
+	}
+	return node.CreationTimestamp
+}
+
+func (r *recorder) deleteJob(job *batchv1.Job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.jobs, job.UID)
+	r.emitLocked(jobEvent(JobDeleted, job, time.Now().UTC()))
+}
+
+// withNode describes the node of the event by the labels of the node.
+func (r *recorder) withNode(event Event, name string) Event {
+	event.Node = name
+	if obj, found, _ := r.nodes.GetByKey(name); found {
+		node := obj.(*corev1.Node)
+		event.Zone = node.Labels[cluster.ZoneLabel]
+		event.InstanceType = node.Labels[cluster.InstanceTypeLabel]
+		event.NodeGroup = node.Labels[cluster.NodeGroupLabel]
+	}
+	return event
+}
+
+func podEvent(eventType EventType, pod *corev1.Pod, t time.Time) Event {
+	event := Event{
+		Time:      t,
+		Type:      eventType,
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		UID:       string(pod.UID),
+		JobID:     pod.Labels[workload.JobIDLabel],
+		TraceID:   pod.Labels[workload.TraceIDLabel],
+		Tenant:    pod.Labels[workload.TenantLabel],
+		Queue:     queue(pod.Labels),
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "Job" {
+		event.Job = owner.Name
+	} else if owner != nil {
+		event.Controller = owner.Kind
+	}
+	return event
+}
+
+func jobEvent(eventType EventType, job *batchv1.Job, t time.Time) Event {
+	return Event{
+		Time:      t,
+		Type:      eventType,
+		Namespace: job.Namespace,
+		Name:      job.Name,
+		UID:       string(job.UID),
+		JobID:     job.Labels[workload.JobIDLabel],
+		TraceID:   job.Labels[workload.TraceIDLabel],
+		Tenant:    job.Labels[workload.TenantLabel],
+		Queue:     queue(job.Labels),
+	}
+}
+
+func nodeEvent(eventType EventType, node *corev1.Node, t time.Time) Event {
+	return Event{
+		Time:         t,
+		Type:         eventType,
+		Name:         node.Name,
+		UID:          string(node.UID),
+		Node:         node.Name,
+		Zone:         node.Labels[cluster.ZoneLabel],
+		InstanceType: node.Labels[cluster.InstanceTypeLabel],
+		NodeGroup:    node.Labels[cluster.NodeGroupLabel],
+	}
+}
+
+func queue(labels map[string]string) string {
+	if queue, found := labels[workload.QueueLabel]; found {
+		return queue
+	}
+	return labels[KueueQueueLabel]
+}
+
+// at returns the time of a transition, taken from the object for objects
+// existing when the recording started.
+func at(objectTime metav1.Time, initial bool) time.Time {
+	if initial && !objectTime.IsZero() {
+		return objectTime.UTC()
+	}
+	return time.Now().UTC()
+}
+
+func podCondition(conditions []corev1.PodCondition, conditionType corev1.PodConditionType) *corev1.PodCondition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+func jobCondition(conditions []batchv1.JobCondition, conditionType batchv1.JobConditionType) *batchv1.JobCondition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+func podStartTime(pod *corev1.Pod) metav1.Time {
+	var started metav1.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		if running := status.State.Running; running != nil && (started.IsZero() || running.StartedAt.Before(&started)) {
+			started = running.StartedAt
+		}
+	}
+	if started.IsZero() && pod.Status.StartTime != nil {
+		started = *pod.Status.StartTime
+	}
+	return started
+}
+
+func podFinishTime(pod *corev1.Pod) metav1.Time {
+	var finished metav1.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		if terminated := status.State.Terminated; terminated != nil && finished.Before(&terminated.FinishedAt) {
+			finished = terminated.FinishedAt
+		}
+	}
+	return finished
+}
+
+// podRequests returns the resources reserved for a Pod: the sum of the
+// container requests, at least the largest init container request, and the
+// overhead. Limits are used for containers without requests, as defaulted by
+// the API server.
+func podRequests(spec corev1.PodSpec) corev1.ResourceList {
+	requests := make(corev1.ResourceList)
+	for _, container := range spec.Containers {
+		for name, quantity := range containerRequests(container) {
+			total := requests[name]
+			total.Add(quantity)
+			requests[name] = total
+		}
+	}
+	for _, container := range spec.InitContainers {
+		for name, quantity := range containerRequests(container) {
+			if current, found := requests[name]; !found || quantity.Cmp(current) > 0 {
+				requests[name] = quantity
+			}
+		}
+	}
+	for name, quantity := range spec.Overhead {
+		total := requests[name]
+		total.Add(quantity)
+		requests[name] = total
+	}
+	return requests
+}
+
+func containerRequests(container corev1.Container) corev1.ResourceList {
+	requests := container.Resources.Limits.DeepCopy()
+	if requests == nil {
+		requests = make(corev1.ResourceList)
+	}
+	for name, quantity := range container.Resources.Requests {
+		requests[name] = quantity
+	}
+	return requests
+}
+
+func resourceMap(resources corev1.ResourceList) map[string]string {
+	out := make(map[string]string)
+	for name, quantity := range resources {
+		if !quantity.IsZero() {
+			out[string(name)] = quantity.String()
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func deletedObject[T any](obj any) (T, bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	object, ok := obj.(T)
+	return object, ok
+}
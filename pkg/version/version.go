@@ -0,0 +1,19 @@
+package version
+
+import "runtime/debug"
+
+// Version of the KEMU binary. It is set at build time with
+// -ldflags "-X github.com/datastrophic/kemu/pkg/version.Version=<version>".
+var Version = ""
+
+// Get returns the KEMU version set at build time, falling back to the module
+// version recorded by `go install` and to "dev" for local builds.
+func Get() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
@@ -0,0 +1,99 @@
+package workload
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ArrivalFixed   = "fixed"
+	ArrivalPoisson = "poisson"
+	ArrivalBurst   = "burst"
+)
+
+// Arrival is a process deciding when workloads are submitted. It is parsed
+// from one of:
+//   - fixed:<interval>, e.g. fixed:30s submits a workload every 30 seconds;
+//   - poisson:<count>/<period>, e.g. poisson:20/h submits 20 workloads per
+//     hour on average with exponentially distributed gaps;
+//   - burst:<count>/<period>, e.g. burst:10/5m submits 10 workloads at once
+//     every 5 minutes.
+type Arrival struct {
+	Process string
+	// Interval is the gap between workloads or bursts.
+	Interval time.Duration
+	// Count is the number of workloads in a burst.
+	Count int
+}
+
+// ParseArrival parses an arrival process specification.
+func ParseArrival(spec string) (Arrival, error) {
+	process, value, _ := strings.Cut(spec, ":")
+	arrival := Arrival{Process: process, Count: 1}
+	var err error
+	switch process {
+	case ArrivalFixed:
+		arrival.Interval, err = time.ParseDuration(value)
+		if err == nil && arrival.Interval < 0 {
+			err = fmt.Errorf("interval must be non-negative")
+		}
+	case ArrivalPoisson, ArrivalBurst:
+		arrival.Count, arrival.Interval, err = parseRate(value)
+		if err == nil && process == ArrivalPoisson {
+			// The rate is kept as the mean gap between workloads.
+			arrival.Interval /= time.Duration(arrival.Count)
+			arrival.Count = 1
+		}
+	default:
+		err = fmt.Errorf("supported processes are %s, %s, and %s", ArrivalFixed, ArrivalPoisson, ArrivalBurst)
+	}
+	if err != nil {
+		return Arrival{}, fmt.Errorf("invalid arrival process %q: %w", spec, err)
+	}
+	return arrival, nil
+}
+
+// Offsets returns the submission times of n workloads relative to the first
+// one.
+func (a Arrival) Offsets(n int, r *rand.Rand) []time.Duration {
+	offsets := make([]time.Duration, n)
+	var offset time.Duration
+	for i := 1; i < n; i++ {
+		switch a.Process {
+		case ArrivalPoisson:
+			offset += time.Duration(r.ExpFloat64() * float64(a.Interval))
+		case ArrivalBurst:
+			if i%a.Count == 0 {
+				offset += a.Interval
+			}
+		default:
+			offset += a.Interval
+		}
+		offsets[i] = offset
+	}
+	return offsets
+}
+
+// parseRate parses <count>/<period> where the period is a duration or one of
+// the s, m, and h units, e.g. 20/h or 10/5m.
+func parseRate(value string) (int, time.Duration, error) {
+	countValue, periodValue, found := strings.Cut(value, "/")
+	if !found {
+		return 0, 0, fmt.Errorf("expected <count>/<period>")
+	}
+	count, err := strconv.Atoi(countValue)
+	if err != nil || count < 1 {
+		return 0, 0, fmt.Errorf("count must be a positive integer")
+	}
+	if len(periodValue) > 0 && !strings.ContainsAny(periodValue[:1], "0123456789.") {
+		periodValue = "1" + periodValue
+	}
+	period, err := time.ParseDuration(periodValue)
+	if err != nil || period <= 0 {
+		return 0, 0, fmt.Errorf("period must be a positive duration")
+	}
+	return count, period, nil
+}
@@ -0,0 +1,78 @@
+package workload
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+const (
+	DistributionUniform     = "uniform"
+	DistributionNormal      = "normal"
+	DistributionExponential = "exponential"
+)
+
+// Distribution of workload durations. It is parsed from one of:
+//   - <duration>, e.g. 10m for the same duration for all workloads;
+//   - uniform:<min>,<max>, e.g. uniform:5m,15m;
+//   - normal:<mean>,<stddev>, e.g. normal:10m,2m;
+//   - exponential:<mean>, e.g. exponential:10m.
+//
+// Sampled durations are rounded to seconds and are never negative.
+type Distribution struct {
+	Kind string
+	// Parameters are min and max for uniform, mean and standard deviation
+	// for normal, and the mean for exponential and constant durations.
+	Parameters []time.Duration
+}
+
+// ParseDistribution parses a duration distribution specification.
+func ParseDistribution(spec string) (Distribution, error) {
+	kind, value, found := strings.Cut(spec, ":")
+	if !found {
+		kind, value = "", spec
+	}
+
+	var parameters []time.Duration
+	for _, parameter := range strings.Split(value, ",") {
+		duration, err := time.ParseDuration(strings.TrimSpace(parameter))
+		if err != nil || duration < 0 {
+			return Distribution{}, fmt.Errorf("invalid duration distribution %q: %q is not a non-negative duration", spec, parameter)
+		}
+		parameters = append(parameters, duration)
+	}
+
+	expected := 1
+	switch kind {
+	case "", DistributionExponential:
+	case DistributionUniform, DistributionNormal:
+		expected = 2
+	default:
+		return Distribution{}, fmt.Errorf("invalid duration distribution %q: supported distributions are %s, %s, and %s",
+			spec, DistributionUniform, DistributionNormal, DistributionExponential)
+	}
+	if len(parameters) != expected {
+		return Distribution{}, fmt.Errorf("invalid duration distribution %q: expected %d parameters", spec, expected)
+	}
+	if kind == DistributionUniform && parameters[0] > parameters[1] {
+		return Distribution{}, fmt.Errorf("invalid duration distribution %q: min is greater than max", spec)
+	}
+	return Distribution{Kind: kind, Parameters: parameters}, nil
+}
+
+// Sample returns a random duration from the distribution.
+func (d Distribution) Sample(r *rand.Rand) time.Duration {
+	var duration float64
+	switch d.Kind {
+	case DistributionUniform:
+		duration = float64(d.Parameters[0]) + r.Float64()*float64(d.Parameters[1]-d.Parameters[0])
+	case DistributionNormal:
+		duration = float64(d.Parameters[0]) + r.NormFloat64()*float64(d.Parameters[1])
+	case DistributionExponential:
+		duration = r.ExpFloat64() * float64(d.Parameters[0])
+	default:
+		duration = float64(d.Parameters[0])
+	}
+	return max(time.Duration(duration), 0).Round(time.Second)
+}
@@ -0,0 +1,204 @@
+package workload
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/datastrophic/kemu/pkg/cluster"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	ReplayKindAuto = "auto"
+	ReplayKindJob  = "job"
+	ReplayKindPod  = "pod"
+
+	// Labels set on replayed workloads and their Pods from the trace records.
+	TraceIDLabel = "kemu.datastrophic.io/trace-id"
+	QueueLabel   = "kemu.datastrophic.io/queue"
+	TenantLabel  = "kemu.datastrophic.io/tenant"
+	GPUTypeLabel = "kemu.datastrophic.io/gpu-type"
+
+	defaultReplayPrefix = "replay"
+	replayImage         = "fake-image"
+)
+
+var invalidLabelValueChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// ReplayOptions configure how trace records become workloads.
+type ReplayOptions struct {
+	// Trace is the path of the trace and Format its format, see ReadTrace.
+	// The format is implied by the file extension when empty.
+	Trace  string
+	Format string
+	// Speedup compresses the submit times and durations of the trace, e.g.
+	// 60 replays an hour of the trace in a minute.
+	Speedup float64
+	// Kind of the workloads: pod, job, or auto for Pods for single-worker
+	// records and Jobs otherwise.
+	Kind string
+	// QueueLabel is an additional Pod label set to the queue of a record,
+	// e.g. kueue.x-k8s.io/queue-name.
+	QueueLabel string
+	// Limit is the maximum number of records to replay, all when zero.
+	Limit int
+	// JobPrefix and RunID prefix job IDs, see SubmitOptions.
+	JobPrefix string
+	RunID     string
+	Namespace string
+	DryRun    bool
+}
+
+// Replay reads a trace and submits a workload for every record, keeping the
+// time between records and their durations compressed by the speedup.
+func Replay(options ReplayOptions, kubeconfig string, out io.Writer) error {
+	format := options.Format
+	if len(format) == 0 {
+		format = TraceFormat(options.Trace)
+	}
+	records, err := ReadTrace(options.Trace, format)
+	if err != nil {
+		return err
+	}
+	submissions, err := PlanReplay(records, options)
+	if err != nil {
+		return err
+	}
+	if len(submissions) > 0 {
+		last := submissions[len(submissions)-1].Offset
+		slog.Info("replaying trace", "records", len(submissions), "speedup", options.Speedup, "duration", last.Round(time.Second))
+	}
+	return Run(submissions, RunOptions{Kubeconfig: kubeconfig, Namespace: options.Namespace, DryRun: options.DryRun}, out)
+}
+
+// PlanReplay turns sorted trace records into workloads submitted at the
+// compressed offsets from the first record.
+func PlanReplay(records []TraceRecord, options ReplayOptions) ([]Submission, error) {
+	if options.Speedup <= 0 {
+		return nil, fmt.Errorf("speedup must be a positive number")
+	}
+	kind := options.Kind
+	if len(kind) == 0 {
+		kind = ReplayKindAuto
+	}
+	if kind != ReplayKindAuto && kind != ReplayKindJob && kind != ReplayKindPod {
+		return nil, fmt.Errorf("unsupported kind %q, supported kinds are %s, %s, and %s", kind, ReplayKindAuto, ReplayKindJob, ReplayKindPod)
+	}
+	if options.Limit > 0 && len(records) > options.Limit {
+		records = records[:options.Limit]
+	}
+	prefix := options.JobPrefix
+	if len(prefix) == 0 {
+		prefix = defaultReplayPrefix
+	}
+	runID := options.RunID
+	if len(runID) == 0 {
+		runID = utilrand.String(5)
+	}
+
+	var submissions []Submission
+	for i, record := range records {
+		if kind == ReplayKindPod && record.Workers > 1 {
+			return nil, fmt.Errorf("trace record %s has %d workers and can't be replayed as a Pod", record.ID, record.Workers)
+		}
+		params := Params{
+			JobID:    fmt.Sprintf("%s-%s-%d", prefix, runID, i),
+			Index:    i,
+			Workers:  record.Workers,
+			GPUType:  record.GPUType,
+			Duration: compress(record.Duration, options.Speedup).Round(time.Millisecond).String(),
+		}
+		object, err := replayObject(record, params, kind, options.QueueLabel)
+		if err != nil {
+			return nil, fmt.Errorf("trace record %s: %w", record.ID, err)
+		}
+		submissions = append(submissions, Submission{
+			Params:  params,
+			Offset:  compress(time.Duration((record.Submit-records[0].Submit)*float64(time.Second)), options.Speedup),
+			Object:  object,
+			TraceID: record.ID,
+		})
+	}
+	return submissions, nil
+}
+
+func replayObject(record TraceRecord, params Params, kind, queueLabel string) (*unstructured.Unstructured, error) {
+	labels := make(map[string]string)
+	for k, v := range record.Labels {
+		labels[k] = labelValue(v)
+	}
+	labels[JobIDLabel] = params.JobID
+	labels[TraceIDLabel] = labelValue(record.ID)
+	for key, value := range map[string]string{QueueLabel: record.Queue, queueLabel: record.Queue, TenantLabel: record.Tenant, GPUTypeLabel: record.GPUType} {
+		if len(key) > 0 && len(value) > 0 {
+			labels[key] = labelValue(value)
+		}
+	}
+
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      labels,
+			Annotations: map[string]string{cluster.PodCompleteDelayAnnotation: params.Duration},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy:     corev1.RestartPolicyNever,
+			PriorityClassName: record.Priority,
+			// Tolerates the default taint of KEMU nodes.
+			Tolerations: []corev1.Toleration{{Key: "kwok.x-k8s.io/node", Value: "fake", Effect: corev1.TaintEffectNoSchedule}},
+			Containers: []corev1.Container{{
+				Name:  "fake-container",
+				Image: replayImage,
+				Resources: corev1.ResourceRequirements{
+					Requests: record.Requests,
+					Limits:   record.Requests,
+				},
+			}},
+		},
+	}
+
+	var object runtime.Object
+	if kind == ReplayKindPod || (kind == ReplayKindAuto && record.Workers == 1) {
+		object = &corev1.Pod{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{GenerateName: params.JobID + "-", Labels: labels, Annotations: template.Annotations},
+			Spec:       template.Spec,
+		}
+	} else {
+		workers := int32(record.Workers)
+		object = &batchv1.Job{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+			ObjectMeta: metav1.ObjectMeta{GenerateName: params.JobID + "-", Labels: maps.Clone(labels)},
+			Spec: batchv1.JobSpec{
+				Completions: &workers,
+				Parallelism: &workers,
+				Template:    template,
+			},
+		}
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
+
+func compress(d time.Duration, speedup float64) time.Duration {
+	return time.Duration(float64(d) / speedup)
+}
+
+// labelValue turns a trace value into a valid label value.
+func labelValue(value string) string {
+	value = invalidLabelValueChars.ReplaceAllString(value, "-")
+	return strings.Trim(value[:min(len(value), validation.LabelValueMaxLength)], "-_.")
+}
@@ -0,0 +1,257 @@
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/datastrophic/kemu/pkg/cluster"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const DefaultNamespace = "default"
+
+// SubmitOptions configure how many workloads are rendered from a template
+// and when they are submitted.
+type SubmitOptions struct {
+	// Template is the path of the workload template.
+	Template string
+	// Count is the number of workloads to submit.
+	Count int
+	// Arrival is the arrival process, see ParseArrival.
+	Arrival string
+	// Duration is the distribution of the Pod completion delays, see
+	// ParseDistribution. Delays set in the template are kept when empty.
+	Duration string
+	// Workers, GPUType, and Values are passed to the template as is.
+	Workers int
+	GPUType string
+	Values  map[string]string
+	// JobPrefix prefixes job IDs and defaults to the template file name.
+	// RunID follows the prefix and defaults to a random string, so that job
+	// IDs of different submissions don't collide.
+	JobPrefix string
+	RunID     string
+	// Namespace of namespaced objects that don't set one.
+	Namespace string
+	// Seed of the random arrivals and durations, random when zero.
+	Seed uint64
+	// DryRun records the workloads without waiting or creating them.
+	DryRun bool
+}
+
+// Submission is a rendered workload and its submission time relative to the
+// first workload.
+type Submission struct {
+	Params Params
+	Offset time.Duration
+	Object *unstructured.Unstructured
+	// TraceID is the ID of the replayed trace record, if any.
+	TraceID string
+}
+
+// Record describes a submitted workload. Records are written as JSON lines.
+type Record struct {
+	Index       int        `json:"index"`
+	JobID       string     `json:"jobId"`
+	TraceID     string     `json:"traceId,omitempty"`
+	APIVersion  string     `json:"apiVersion"`
+	Kind        string     `json:"kind"`
+	Namespace   string     `json:"namespace,omitempty"`
+	Name        string     `json:"name,omitempty"`
+	Offset      string     `json:"offset"`
+	Duration    string     `json:"duration,omitempty"`
+	SubmittedAt *time.Time `json:"submittedAt,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Plan renders the workloads and decides when to submit them.
+func Plan(options SubmitOptions) ([]Submission, error) {
+	if options.Count < 1 {
+		return nil, fmt.Errorf("count must be a positive number")
+	}
+	arrival, err := ParseArrival(options.Arrival)
+	if err != nil {
+		return nil, err
+	}
+	var distribution *Distribution
+	if len(options.Duration) > 0 {
+		d, err := ParseDistribution(options.Duration)
+		if err != nil {
+			return nil, err
+		}
+		distribution = &d
+	}
+	t, err := LoadTemplate(options.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	seed := options.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	r := rand.New(rand.NewPCG(seed, seed))
+	prefix := options.JobPrefix
+	if len(prefix) == 0 {
+		prefix = strings.TrimSuffix(filepath.Base(options.Template), filepath.Ext(options.Template))
+	}
+	runID := options.RunID
+	if len(runID) == 0 {
+		runID = utilrand.String(5)
+	}
+
+	var submissions []Submission
+	for i, offset := range arrival.Offsets(options.Count, r) {
+		params := Params{
+			JobID:   fmt.Sprintf("%s-%s-%d", prefix, runID, i),
+			Index:   i,
+			Workers: options.Workers,
+			GPUType: options.GPUType,
+			Values:  options.Values,
+		}
+		if distribution != nil {
+			params.Duration = distribution.Sample(r).String()
+		}
+		object, err := t.Render(params)
+		if err != nil {
+			return nil, err
+		}
+		// Delays are set on the Pods even if the template doesn't use them.
+		if distribution != nil {
+			if err = setPodAnnotation(object, cluster.PodCompleteDelayAnnotation, params.Duration); err != nil {
+				return nil, err
+			}
+		}
+		submissions = append(submissions, Submission{Params: params, Offset: offset, Object: object})
+	}
+	return submissions, nil
+}
+
+// Submit plans the workloads and creates them in the cluster following the
+// arrival process.
+func Submit(options SubmitOptions, kubeconfig string, out io.Writer) error {
+	submissions, err := Plan(options)
+	if err != nil {
+		return err
+	}
+	return Run(submissions, RunOptions{Kubeconfig: kubeconfig, Namespace: options.Namespace, DryRun: options.DryRun}, out)
+}
+
+// RunOptions configure where planned workloads are submitted.
+type RunOptions struct {
+	Kubeconfig string
+	// Namespace of namespaced objects that don't set one.
+	Namespace string
+	// DryRun records the workloads without waiting or creating them.
+	DryRun bool
+}
+
+// Run creates the workloads at their offsets from now and writes a record of
+// every workload to out. Failed submissions are recorded and don't stop the
+// following ones.
+func Run(submissions []Submission, options RunOptions, out io.Writer) error {
+	namespace := options.Namespace
+	if len(namespace) == 0 {
+		namespace = DefaultNamespace
+	}
+
+	var create func(*unstructured.Unstructured) (*unstructured.Unstructured, error)
+	if !options.DryRun {
+		var err error
+		if create, err = creator(options.Kubeconfig, namespace); err != nil {
+			return err
+		}
+	}
+
+	encoder := json.NewEncoder(out)
+	start := time.Now()
+	failed := 0
+	for _, submission := range submissions {
+		object := submission.Object
+		record := Record{
+			Index:      submission.Params.Index,
+			JobID:      submission.Params.JobID,
+			TraceID:    submission.TraceID,
+			APIVersion: object.GetAPIVersion(),
+			Kind:       object.GetKind(),
+			Namespace:  object.GetNamespace(),
+			Name:       object.GetName(),
+			Offset:     submission.Offset.Round(time.Millisecond).String(),
+			Duration:   submission.Params.Duration,
+		}
+		if create != nil {
+			time.Sleep(time.Until(start.Add(submission.Offset)))
+			created, err := create(object)
+			submittedAt := time.Now().UTC()
+			record.SubmittedAt = &submittedAt
+			if err != nil {
+				failed++
+				record.Error = err.Error()
+				slog.Warn("failed to submit workload", "job", record.JobID, "error", err)
+			} else {
+				record.Namespace = created.GetNamespace()
+				record.Name = created.GetName()
+				slog.Info("submitted workload", "job", record.JobID, "kind", record.Kind, "name", record.Name)
+			}
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to submit %d of %d workloads", failed, len(submissions))
+	}
+	return nil
+}
+
+// creator returns a function creating objects of any kind known to the API
+// server, setting the namespace of namespaced objects that don't have one.
+func creator(kubeconfig, namespace string) (func(*unstructured.Unstructured) (*unstructured.Unstructured, error), error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+
+	return func(object *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+		gvk := object.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			if meta.IsNoMatchError(err) {
+				return nil, fmt.Errorf("the cluster doesn't serve %s, is the addon providing it installed?", gvk)
+			}
+			return nil, err
+		}
+		if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+			return dynamicClient.Resource(mapping.Resource).Create(context.TODO(), object, metav1.CreateOptions{})
+		}
+		if len(object.GetNamespace()) == 0 {
+			object.SetNamespace(namespace)
+		}
+		return dynamicClient.Resource(mapping.Resource).Namespace(object.GetNamespace()).Create(context.TODO(), object, metav1.CreateOptions{})
+	}, nil
+}
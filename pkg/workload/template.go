@@ -0,0 +1,143 @@
+package workload
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// JobIDLabel is set on submitted objects and their Pods to the job ID.
+const JobIDLabel = "kemu.datastrophic.io/job-id"
+
+// Params are the values available to workload templates. For example,
+// {{ .JobID }} gives each workload a unique label for Pod affinity and
+// {{ default 8 .Workers }} falls back to 8 workers when --workers isn't set.
+type Params struct {
+	// JobID is unique for every submitted workload.
+	JobID string
+	// Index is the number of the workload in the submission, from 0.
+	Index int
+	// Workers, GPUType, and Duration are empty unless set by the user.
+	Workers  int
+	GPUType  string
+	Duration string
+	// Values are arbitrary key-value pairs, e.g. {{ .Values.queue }}.
+	Values map[string]string
+}
+
+// Template is a Go template of a single Kubernetes object, e.g. a Job,
+// a JobSet, or a Pod.
+type Template struct {
+	template *template.Template
+}
+
+// LoadTemplate parses the workload template at path.
+func LoadTemplate(path string) (*Template, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := template.New(filepath.Base(path)).
+		Option("missingkey=zero").
+		Funcs(template.FuncMap{"default": defaultValue}).
+		Parse(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse workload template: %w", err)
+	}
+	return &Template{template: t}, nil
+}
+
+// Render executes the template and labels the object and its Pods with the
+// job ID.
+func (t *Template) Render(params Params) (*unstructured.Unstructured, error) {
+	var out bytes.Buffer
+	if err := t.template.Execute(&out, params); err != nil {
+		return nil, fmt.Errorf("failed to render workload template: %w", err)
+	}
+	data, err := yaml.YAMLToJSON(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rendered workload %s: %w", params.JobID, err)
+	}
+	object := &unstructured.Unstructured{}
+	if err = object.UnmarshalJSON(data); err != nil {
+		return nil, fmt.Errorf("failed to parse rendered workload %s: %w", params.JobID, err)
+	}
+
+	object.SetLabels(withEntry(object.GetLabels(), JobIDLabel, params.JobID))
+	for _, metadata := range podTemplateMetadata(object.Object) {
+		setEntry(metadata, "labels", JobIDLabel, params.JobID)
+	}
+	return object, nil
+}
+
+// setPodAnnotation sets the annotation on all Pods of the object.
+func setPodAnnotation(object *unstructured.Unstructured, key, value string) error {
+	metadata := podTemplateMetadata(object.Object)
+	if len(metadata) == 0 {
+		return fmt.Errorf("%s %s has no Pod template", object.GetKind(), object.GetGenerateName()+object.GetName())
+	}
+	for _, m := range metadata {
+		setEntry(m, "annotations", key, value)
+	}
+	return nil
+}
+
+// podTemplateMetadata returns the metadata of a Pod or of the Pod templates
+// of workloads such as Jobs, CronJobs, Deployments, and JobSets.
+func podTemplateMetadata(object map[string]any) []map[string]any {
+	if object["kind"] == "Pod" {
+		return []map[string]any{childMap(object, "metadata")}
+	}
+	spec, _ := object["spec"].(map[string]any)
+	if template, found := spec["template"].(map[string]any); found {
+		return []map[string]any{childMap(template, "metadata")}
+	}
+	if jobTemplate, found := spec["jobTemplate"].(map[string]any); found {
+		return podTemplateMetadata(jobTemplate)
+	}
+
+	var metadata []map[string]any
+	replicatedJobs, _ := spec["replicatedJobs"].([]any)
+	for _, replicatedJob := range replicatedJobs {
+		replicatedJob, _ := replicatedJob.(map[string]any)
+		if jobTemplate, found := replicatedJob["template"].(map[string]any); found {
+			metadata = append(metadata, podTemplateMetadata(jobTemplate)...)
+		}
+	}
+	return metadata
+}
+
+func childMap(m map[string]any, key string) map[string]any {
+	child, found := m[key].(map[string]any)
+	if !found {
+		child = make(map[string]any)
+		m[key] = child
+	}
+	return child
+}
+
+func setEntry(metadata map[string]any, field, key, value string) {
+	childMap(metadata, field)[key] = value
+}
+
+func withEntry(m map[string]string, key, value string) map[string]string {
+	if m == nil {
+		m = make(map[string]string)
+	}
+	m[key] = value
+	return m
+}
+
+// defaultValue returns value unless it is empty, e.g. {{ default 8 .Workers }}.
+func defaultValue(fallback, value any) any {
+	if value == nil || reflect.ValueOf(value).IsZero() {
+		return fallback
+	}
+	return value
+}
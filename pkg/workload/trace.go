@@ -0,0 +1,359 @@
+package workload
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/datastrophic/kemu/pkg/api"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	TraceFormatCSV     = "csv"
+	TraceFormatJSONL   = "jsonl"
+	TraceFormatAlibaba = "alibaba"
+
+	// alibabaJobTable is read next to the task table for the users of jobs.
+	alibabaJobTable = "pai_job_table.csv"
+
+	gpuResource = corev1.ResourceName(api.GPUResource)
+)
+
+// TraceRecord is a job of a trace.
+type TraceRecord struct {
+	ID string
+	// Submit is the submission time in seconds. Only the differences between
+	// records matter, so it can be a Unix time or relative to the trace start.
+	Submit   float64
+	Duration time.Duration
+	// Workers is the number of Pods, each one requesting Requests.
+	Workers  int
+	Requests corev1.ResourceList
+	// Priority is the name of the PriorityClass of the Pods.
+	Priority string
+	Queue    string
+	Tenant   string
+	GPUType  string
+	Labels   map[string]string
+}
+
+// traceFields are the columns of CSV traces and the fields of JSONL traces.
+var traceFields = []string{"id", "submitTime", "duration", "workers", "cpu", "memory", "gpu", "priority", "queue", "tenant", "gpuType"}
+
+// TraceFormat returns the trace format implied by the file extension.
+func TraceFormat(path string) string {
+	switch filepath.Ext(path) {
+	case ".csv":
+		return TraceFormatCSV
+	case ".jsonl", ".json":
+		return TraceFormatJSONL
+	}
+	return ""
+}
+
+// ReadTrace reads the records of a trace in one of the formats:
+//   - csv with a header naming the columns, e.g. id,submitTime,duration,
+//     workers,cpu,memory,gpu,priority,queue,tenant,gpuType;
+//   - jsonl with an object of the same fields per line and optional labels;
+//   - alibaba, the pai_task_table.csv of the Alibaba GPU cluster trace
+//     (cluster-trace-gpu-v2020). Users are read from pai_job_table.csv in
+//     the same directory when it exists.
+//
+// Submit times are seconds or RFC 3339 timestamps, durations are seconds or
+// Go durations, and resources are Kubernetes quantities per worker. Records
+// are returned sorted by submit time.
+func ReadTrace(path, format string) ([]TraceRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []TraceRecord
+	switch format {
+	case TraceFormatCSV:
+		records, err = readCSVTrace(file)
+	case TraceFormatJSONL:
+		records, err = readJSONLTrace(file)
+	case TraceFormatAlibaba:
+		records, err = readAlibabaTrace(file, filepath.Join(filepath.Dir(path), alibabaJobTable))
+	default:
+		return nil, fmt.Errorf("unsupported trace format %q, supported formats are %s, %s, and %s",
+			format, TraceFormatCSV, TraceFormatJSONL, TraceFormatAlibaba)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trace %q: %w", path, err)
+	}
+	slices.SortStableFunc(records, func(a, b TraceRecord) int {
+		return cmp.Compare(a.Submit, b.Submit)
+	})
+	return records, nil
+}
+
+func readCSVTrace(in io.Reader) ([]TraceRecord, error) {
+	reader := csv.NewReader(in)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	for _, column := range header {
+		if !slices.Contains(traceFields, column) {
+			return nil, fmt.Errorf("unknown column %q, supported columns are %s", column, strings.Join(traceFields, ", "))
+		}
+	}
+
+	var records []TraceRecord
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]string)
+		for i, column := range header {
+			fields[column] = row[i]
+		}
+		record, err := parseTraceRecord(fields, strconv.Itoa(line-2))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+}
+
+func readJSONLTrace(in io.Reader) ([]TraceRecord, error) {
+	var records []TraceRecord
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var object map[string]any
+		decoder := json.NewDecoder(strings.NewReader(scanner.Text()))
+		decoder.UseNumber()
+		if err := decoder.Decode(&object); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		fields := make(map[string]string)
+		var labels map[string]string
+		for key, value := range object {
+			if key == "labels" {
+				values, _ := value.(map[string]any)
+				labels = make(map[string]string)
+				for k, v := range values {
+					labels[k] = fmt.Sprint(v)
+				}
+				continue
+			}
+			if !slices.Contains(traceFields, key) {
+				return nil, fmt.Errorf("line %d: unknown field %q", line, key)
+			}
+			fields[key] = fmt.Sprint(value)
+		}
+		record, err := parseTraceRecord(fields, strconv.Itoa(len(records)))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		record.Labels = labels
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+func parseTraceRecord(fields map[string]string, defaultID string) (TraceRecord, error) {
+	record := TraceRecord{
+		ID:       fields["id"],
+		Workers:  1,
+		Requests: make(corev1.ResourceList),
+		Priority: fields["priority"],
+		Queue:    fields["queue"],
+		Tenant:   fields["tenant"],
+		GPUType:  fields["gpuType"],
+	}
+	if len(record.ID) == 0 {
+		record.ID = defaultID
+	}
+
+	var err error
+	if record.Submit, err = parseSubmitTime(fields["submitTime"]); err != nil {
+		return TraceRecord{}, fmt.Errorf("invalid submitTime: %w", err)
+	}
+	if record.Duration, err = parseTraceDuration(fields["duration"]); err != nil {
+		return TraceRecord{}, fmt.Errorf("invalid duration: %w", err)
+	}
+	if workers := fields["workers"]; len(workers) > 0 {
+		if record.Workers, err = strconv.Atoi(workers); err != nil || record.Workers < 1 {
+			return TraceRecord{}, fmt.Errorf("invalid workers %q: must be a positive integer", workers)
+		}
+	}
+	for field, name := range map[string]corev1.ResourceName{"cpu": corev1.ResourceCPU, "memory": corev1.ResourceMemory, "gpu": gpuResource} {
+		value := fields[field]
+		if len(value) == 0 {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return TraceRecord{}, fmt.Errorf("invalid %s %q: %w", field, value, err)
+		}
+		if !quantity.IsZero() {
+			record.Requests[name] = quantity
+		}
+	}
+	return record, nil
+}
+
+func parseSubmitTime(value string) (float64, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return seconds, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is neither seconds nor an RFC 3339 timestamp", value)
+	}
+	return float64(t.UnixNano()) / float64(time.Second), nil
+}
+
+func parseTraceDuration(value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, parseErr := strconv.ParseFloat(value, 64)
+		if parseErr != nil {
+			return 0, fmt.Errorf("%q is neither seconds nor a duration", value)
+		}
+		duration = time.Duration(seconds * float64(time.Second))
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("%q is negative", value)
+	}
+	return duration, nil
+}
+
+// readAlibabaTrace reads the task table of the Alibaba GPU cluster trace with
+// the columns job_name, task_name, inst_num, status, start_time, end_time,
+// plan_cpu, plan_mem, plan_gpu, and gpu_type. Every task becomes a record
+// with inst_num workers. CPU is in percent of a core, memory in GB, and GPU
+// in percent of a GPU, rounded up to whole GPUs. Tasks that never started
+// or ended are skipped. Tasks are submitted with their job, so the queueing
+// of the traced cluster isn't replayed, and last as long as they ran.
+func readAlibabaTrace(in io.Reader, jobTable string) ([]TraceRecord, error) {
+	jobs, err := readAlibabaJobs(jobTable)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = 10
+	var records []TraceRecord
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && row[0] == "job_name" {
+			continue
+		}
+		start, startErr := strconv.ParseFloat(row[4], 64)
+		end, endErr := strconv.ParseFloat(row[5], 64)
+		if startErr != nil || endErr != nil || end < start {
+			continue
+		}
+		instances, err := strconv.ParseFloat(row[2], 64)
+		if err != nil || instances < 1 {
+			return nil, fmt.Errorf("line %d: invalid inst_num %q", line, row[2])
+		}
+
+		record := TraceRecord{
+			ID:       row[0] + "-" + row[1],
+			Submit:   start,
+			Duration: time.Duration((end - start) * float64(time.Second)),
+			Workers:  int(instances),
+			Requests: make(corev1.ResourceList),
+			GPUType:  row[9],
+		}
+		if job, found := jobs[row[0]]; found {
+			record.Tenant = job.user
+			if job.submitted && job.submit <= start {
+				record.Submit = job.submit
+			}
+		}
+		for i, resourceName := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory, gpuResource} {
+			value, err := strconv.ParseFloat(row[6+i], 64)
+			if err != nil || value <= 0 {
+				continue
+			}
+			switch resourceName {
+			case corev1.ResourceCPU:
+				record.Requests[resourceName] = *resource.NewMilliQuantity(int64(math.Ceil(value*10)), resource.DecimalSI)
+			case corev1.ResourceMemory:
+				record.Requests[resourceName] = *resource.NewScaledQuantity(int64(math.Ceil(value*1000)), resource.Mega)
+			case gpuResource:
+				record.Requests[resourceName] = *resource.NewQuantity(int64(math.Ceil(value/100)), resource.DecimalSI)
+			}
+		}
+		records = append(records, record)
+	}
+}
+
+// alibabaJob is a job of the job table. The start time of a job is the time
+// it was submitted.
+type alibabaJob struct {
+	user      string
+	submit    float64
+	submitted bool
+}
+
+// readAlibabaJobs returns the jobs of the job table with the columns
+// job_name, inst_id, user, status, start_time, and end_time.
+func readAlibabaJobs(path string) (map[string]alibabaJob, error) {
+	jobs := make(map[string]alibabaJob)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return jobs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return jobs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %w", path, err)
+		}
+		if len(row) < 3 {
+			continue
+		}
+		job := alibabaJob{user: row[2]}
+		if len(row) > 4 {
+			submit, err := strconv.ParseFloat(row[4], 64)
+			job.submit, job.submitted = submit, err == nil
+		}
+		jobs[row[0]] = job
+	}
+}
@@ -0,0 +1,76 @@
+package test
+
+import (
+	"fmt"
+
+	"github.com/datastrophic/kemu/pkg/cluster"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("cluster config validation", func() {
+	It("should accept all test and example configs", func() {
+		configs := []string{
+			"test/testdata/simple.yaml",
+			"test/testdata/with-addons.yaml",
+			"test/testdata/with-broken-addon.yaml",
+			"test/testdata/with-full-config.yaml",
+			"test/testdata/with-kind-config.yaml",
+			"test/testdata/with-kwok-nodes.yaml",
+			"test/testdata/with-embedded-charts.yaml",
+			"test/testdata/with-kwok-profile.yaml",
+			"test/testdata/with-taints.yaml",
+			"test/testdata/with-reservations.yaml",
+			"test/testdata/with-node-info.yaml",
+			"test/testdata/with-zone-spread.yaml",
+			"test/testdata/with-topology.yaml",
+			"test/testdata/with-instance-types.yaml",
+			"examples/gcp-example.yaml",
+			"examples/gcp-small.yaml",
+			"examples/gcp-large.yaml",
+		}
+		for _, config := range configs {
+			err := cluster.ValidateKemuClusterConfig(fmt.Sprintf("%s/%s", rootProjectDir, config))
+			Expect(err).NotTo(HaveOccurred(), fmt.Sprintf("expected %s to be valid", config))
+		}
+	})
+
+	It("should report all problems of an invalid config at once", func() {
+		err := cluster.ValidateKemuClusterConfig(fmt.Sprintf("%s/test/testdata/invalid.yaml", rootProjectDir))
+		Expect(err).To(HaveOccurred(), "expected invalid config to fail validation")
+
+		expected := []string{
+			"field zones not found",
+			"apiVersion: Unsupported value",
+			"spec.kindConfig.apiVersion: Required value",
+			"spec.nodeGroups[0].placement[1].availabilityZone: Duplicate value",
+			"spec.nodeGroups[0].placement[1].replicas: Invalid value",
+			"spec.nodeGroups[0].placement[2].availabilityZone: Required value",
+			"spec.nodeGroups[0].nodeTemplate.capacity[cpu]: Invalid value: \"96x\"",
+			"spec.nodeGroups[0].nodeTemplate.capacity[gpus]: Invalid value: \"gpus\": must be one of cpu, memory, ephemeral-storage, pods",
+			"spec.nodeGroups[0].nodeTemplate.metadata.name: Forbidden: node names are generated",
+			"spec.nodeGroups[0].nodeTemplate.metadata.annotations[kwok.x-k8s.io/node]: Forbidden",
+			"spec.nodeGroups[0].nodeTemplate.taints[0].effect: Unsupported value: \"NoRun\"",
+			"spec.nodeGroups[0].nodeTemplate.allocatable: Forbidden: can't be combined with",
+			"spec.nodeGroups[0].nodeTemplate.allocatable[gpus]: Invalid value: \"16\": must not exceed capacity",
+			"spec.nodeGroups[0].nodeTemplate.reservationPreset: Unsupported value: \"azure\"",
+			"spec.nodeGroups[0].nodeTemplate.nodeInfo.architecture: Unsupported value: \"x86\"",
+			"spec.nodeGroups[0].nodeTemplate.nodeInfo.kubeletVersion: Invalid value: \"1.33\"",
+			"spec.nodeGroups[1].name: Duplicate value",
+			"spec.nodeGroups[1].placement[0].replicas: Forbidden: can't be set along with replicas of the node group",
+			"spec.nodeGroups[2].instanceType: Unsupported value: \"gcp/a9-megagpu-8g\"",
+			"spec.nodeGroups[2].placement[0].replicas: Invalid value: 100: must not exceed the capacity of the topology (36 nodes)",
+			"spec.nodeGroups[2].placement[1].topology.levels[0].label: Forbidden: is set by KEMU from the placement",
+			"spec.nodeGroups[2].placement[1].topology.levels[0].domains: Invalid value: 0",
+			"spec.nodeGroups[3]: Invalid value: \"a-very-long-node-group-name-for-the-node-name-check-us-central1-a-9\": node names must be valid hostname labels",
+			"spec.clusterAddons[0].repoName: Required value",
+			"spec.clusterAddons[0].repoURL: Required value",
+			"spec.clusterAddons[1].chart: Invalid value: \"./charts/missing-0.1.0.tgz\": local chart is not accessible",
+			"spec.kwok.profile: Unsupported value: \"slow\"",
+			"spec.kwok.stages: Invalid value",
+		}
+		for _, msg := range expected {
+			Expect(err.Error()).To(ContainSubstring(msg))
+		}
+	})
+})
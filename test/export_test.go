@@ -0,0 +1,84 @@
+package test
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/datastrophic/kemu/pkg/api"
+	"github.com/datastrophic/kemu/pkg/cluster"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+var _ = Describe("cluster export", func() {
+	It("should group nodes back into the node groups they were created from", func() {
+		nodes := renderedNodes(fmt.Sprintf("%s/test/testdata/with-kwok-nodes.yaml", rootProjectDir))
+
+		nodeGroups := cluster.ExportNodeGroups(nodes, nil, cluster.DefaultKubeletVersion)
+		Expect(nodeGroups).To(HaveLen(2))
+		Expect(nodeGroups[0].Name).To(Equal("a2-ultragpu-8g"))
+		Expect(nodeGroups[0].Placement).To(Equal([]api.Placement{
+			{AvailabilityZone: "use1", Replicas: 5},
+			{AvailabilityZone: "use2", Replicas: 5},
+			{AvailabilityZone: "use3", Replicas: 5},
+		}))
+		Expect(nodeGroups[0].NodeTemplate.Labels).To(Equal(map[string]string{"datastrophic.io/gpu-type": "nvidia-a100-80gb"}))
+		Expect(nodeGroups[0].NodeTemplate.Capacity).To(HaveKeyWithValue("ephemeral-storage", "3Ti"))
+		Expect(nodeGroups[0].NodeTemplate.NodeInfo).To(BeZero())
+		Expect(nodeGroups[0].NodeTemplate.DisableDefaultTaint).To(BeFalse())
+		Expect(nodeGroups[1].Name).To(Equal("a3-highgpu-8g"))
+		Expect(nodeGroups[1].Placement).To(Equal([]api.Placement{
+			{AvailabilityZone: "use1", Replicas: 10},
+			{AvailabilityZone: "use2", Replicas: 10},
+		}))
+	})
+
+	It("should split node groups whose nodes were changed", func() {
+		nodes := renderedNodes(fmt.Sprintf("%s/test/testdata/with-kwok-nodes.yaml", rootProjectDir))
+		for i := range nodes {
+			if nodes[i].Name == "a3-highgpu-8g-use1-0" || nodes[i].Name == "a3-highgpu-8g-use2-0" {
+				nodes[i].Labels["datastrophic.io/pool"] = "inference"
+			}
+		}
+
+		nodeGroups := cluster.ExportNodeGroups(nodes, nil, cluster.DefaultKubeletVersion)
+		Expect(nodeGroups).To(HaveLen(3))
+		relabeled := nodeGroups[2]
+		Expect(relabeled.Name).To(Equal("a3-highgpu-8g-2"))
+		Expect(relabeled.Placement).To(Equal([]api.Placement{
+			{AvailabilityZone: "use1", Replicas: 1},
+			{AvailabilityZone: "use2", Replicas: 1},
+		}))
+		// The renamed node group keeps the instance type of its nodes.
+		Expect(relabeled.NodeTemplate.Labels).To(Equal(map[string]string{
+			"datastrophic.io/gpu-type": "nvidia-h100-80gb",
+			"datastrophic.io/pool":     "inference",
+			cluster.InstanceTypeLabel:  "a3-highgpu-8g",
+		}))
+		Expect(nodeGroups[1].Placement).To(Equal([]api.Placement{
+			{AvailabilityZone: "use1", Replicas: 9},
+			{AvailabilityZone: "use2", Replicas: 9},
+		}))
+	})
+
+	It("should restore zone topologies from the cluster record", func() {
+		path := fmt.Sprintf("%s/test/testdata/with-topology.yaml", rootProjectDir)
+		nodes := renderedNodes(path)
+		data, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		var record api.ClusterConfig
+		Expect(yaml.Unmarshal(data, &record)).To(Succeed())
+
+		nodeGroups := cluster.ExportNodeGroups(nodes, &record, cluster.DefaultKubeletVersion)
+		Expect(nodeGroups).To(HaveLen(2))
+		megagpu := nodeGroups[0]
+		Expect(megagpu.Name).To(Equal("a3-megagpu-8g"))
+		Expect(megagpu.NodeTemplate.Labels).To(BeEmpty())
+		Expect(megagpu.Placement).To(Equal([]api.Placement{
+			{AvailabilityZone: "use1", Region: "us-east1", Replicas: 60, Topology: record.Spec.NodeGroups[0].Placement[0].Topology},
+		}))
+		Expect(nodeGroups[1].Placement).To(HaveLen(2))
+		Expect(nodeGroups[1].Placement[1].Replicas).To(Equal(27))
+	})
+})
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/datastrophic/kemu/pkg/api"
+	"github.com/datastrophic/kemu/pkg/cluster"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+var _ = Describe("node import", func() {
+	var options cluster.ImportOptions
+	BeforeEach(func() {
+		options = cluster.ImportOptions{
+			From:   fmt.Sprintf("%s/test/testdata/nodes.yaml", rootProjectDir),
+			Labels: []string{"datastrophic.io/pool", "cloud.google.com/gke-accelerator", "vpc.amazonaws.com/*"},
+			Taints: []string{"nvidia.com/gpu"},
+		}
+	})
+
+	It("should group nodes by instance type and zone", func() {
+		config, err := cluster.ImportNodes(options)
+		Expect(err).NotTo(HaveOccurred(), "failed to import nodes")
+
+		nodeGroups := make(map[string]api.NodeGroup)
+		for _, nodeGroup := range config.Spec.NodeGroups {
+			nodeGroups[nodeGroup.Name] = nodeGroup
+		}
+		Expect(nodeGroups).To(HaveLen(4))
+
+		// Nodes differing in allowlisted labels become separate node groups.
+		training := nodeGroups["a3-highgpu-8g"]
+		Expect(training.Placement).To(Equal([]api.Placement{
+			{AvailabilityZone: "us-central1-a", Region: "us-central1", Replicas: 2},
+			{AvailabilityZone: "us-central1-b", Region: "us-central1", Replicas: 1},
+		}))
+		Expect(training.NodeTemplate.Labels).To(Equal(map[string]string{
+			"datastrophic.io/pool":             "training",
+			"cloud.google.com/gke-accelerator": "nvidia-h100-80gb",
+		}))
+		Expect(training.NodeTemplate.Taints).To(Equal([]api.Taint{{Key: "nvidia.com/gpu", Value: "present", Effect: "NoSchedule"}}))
+		Expect(training.NodeTemplate.Capacity).To(HaveKeyWithValue("nvidia.com/gpu", "8"))
+		Expect(training.NodeTemplate.Capacity).NotTo(HaveKey("hugepages-1Gi"))
+		Expect(training.NodeTemplate.Allocatable).To(HaveKeyWithValue("cpu", "207690m"))
+		Expect(training.NodeTemplate.NodeInfo.KubeletVersion).To(BeEmpty())
+		Expect(training.NodeTemplate.NodeInfo.OSImage).To(Equal("Container-Optimized OS from Google"))
+
+		inference := nodeGroups["a3-highgpu-8g-2"]
+		Expect(inference.NodeTemplate.Labels).To(HaveKeyWithValue("datastrophic.io/pool", "inference"))
+		Expect(inference.Placement).To(Equal([]api.Placement{{AvailabilityZone: "us-central1-b", Region: "us-central1", Replicas: 1}}))
+
+		Expect(nodeGroups["e2-standard-8"].NodeTemplate.Labels).To(BeEmpty())
+		Expect(nodeGroups["e2-standard-8"].NodeTemplate.Taints).To(BeEmpty())
+
+		// Instance types that aren't valid names are kept in the label.
+		aws := nodeGroups["p5-48xlarge"]
+		Expect(aws.NodeTemplate.Labels).To(Equal(map[string]string{
+			cluster.InstanceTypeLabel:       "p5.48xlarge",
+			"vpc.amazonaws.com/efa.present": "true",
+		}))
+		Expect(aws.NodeTemplate.Capacity).To(HaveKeyWithValue("vpc.amazonaws.com/efa", "32"))
+		Expect(aws.NodeTemplate.Capacity).NotTo(HaveKey("attachable-volumes-aws-ebs"))
+		Expect(aws.NodeTemplate.Allocatable).NotTo(HaveKey("attachable-volumes-aws-ebs"))
+	})
+
+	It("should not carry over identifying data", func() {
+		config, err := cluster.ImportNodes(options)
+		Expect(err).NotTo(HaveOccurred(), "failed to import nodes")
+
+		out, err := yaml.Marshal(config)
+		Expect(err).NotTo(HaveOccurred())
+		for _, identifying := range []string{"gke-prod-", "ip-10-1-12-34", "10.0.0.11", "gce://", "machineID", "instance_id"} {
+			Expect(string(out)).NotTo(ContainSubstring(identifying))
+		}
+	})
+
+	It("should produce a config that renders the same number of nodes", func() {
+		config, err := cluster.ImportNodes(options)
+		Expect(err).NotTo(HaveOccurred(), "failed to import nodes")
+
+		out, err := yaml.Marshal(config)
+		Expect(err).NotTo(HaveOccurred())
+		path := filepath.Join(GinkgoT().TempDir(), "imported.yaml")
+		Expect(os.WriteFile(path, out, 0o644)).To(Succeed())
+
+		Expect(renderedNodes(path)).To(HaveLen(6))
+	})
+
+	It("should keep node names of long instance types valid", func() {
+		instanceType := strings.Repeat("custom-", 8) + "gpu-8gb"
+		node := func(pool string) string {
+			return fmt.Sprintf(`- apiVersion: v1
+  kind: Node
+  metadata:
+    name: node-%s
+    labels:
+      datastrophic.io/pool: %s
+      node.kubernetes.io/instance-type: %s
+      topology.kubernetes.io/zone: us-central1-a
+  status:
+    capacity:
+      cpu: "8"
+      memory: 32Gi
+`, pool, pool, instanceType)
+		}
+		options.From = filepath.Join(GinkgoT().TempDir(), "nodes.yaml")
+		nodes := "apiVersion: v1\nkind: List\nitems:\n" + node("training") + node("inference")
+		Expect(os.WriteFile(options.From, []byte(nodes), 0o644)).To(Succeed())
+
+		config, err := cluster.ImportNodes(options)
+		Expect(err).NotTo(HaveOccurred(), "failed to import nodes")
+		var names []string
+		for _, nodeGroup := range config.Spec.NodeGroups {
+			names = append(names, nodeGroup.Name)
+		}
+		// Node names are suffixed with the zone and the index, -us-central1-a-0.
+		Expect(names).To(ConsistOf(instanceType[:47], instanceType[:45]+"-2"))
+
+		out, err := yaml.Marshal(config)
+		Expect(err).NotTo(HaveOccurred())
+		path := filepath.Join(GinkgoT().TempDir(), "imported.yaml")
+		Expect(os.WriteFile(path, out, 0o644)).To(Succeed())
+		for _, node := range renderedNodes(path) {
+			Expect(len(node.Name)).To(BeNumerically("<=", 63), node.Name)
+			Expect(node.Labels).To(HaveKeyWithValue(cluster.InstanceTypeLabel, instanceType))
+		}
+	})
+
+	It("should require nodes to import", func() {
+		options.From = fmt.Sprintf("%s/test/testdata/simple.yaml", rootProjectDir)
+		_, err := cluster.ImportNodes(options)
+		Expect(err).To(MatchError(ContainSubstring("no nodes to import")))
+	})
+})
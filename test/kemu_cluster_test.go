@@ -3,15 +3,26 @@ package test
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"time"
 
+	"github.com/datastrophic/kemu/pkg/api"
 	"github.com/datastrophic/kemu/pkg/cluster"
+	"github.com/datastrophic/kemu/pkg/timeline"
+	"github.com/datastrophic/kemu/pkg/workload"
 	"github.com/datastrophic/kemu/test/utils"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+	kwokclient "sigs.k8s.io/kwok/pkg/client/clientset/versioned"
 )
 
 // Create client from kubeconfig with assertions.
@@ -74,6 +85,97 @@ var _ = Describe("kemu API", func() {
 		})
 	})
 
+	Context("with failed bootstrap", Ordered, func() {
+		clusterName := "it-with-failure"
+		kubeconfig := fmt.Sprintf("%s/.run/it-with-failure.config", rootProjectDir)
+
+		It("should delete the cluster when cleanup on failure is requested", func() {
+			err := cluster.CreateKemuCluster(fmt.Sprintf("%s/test/testdata/with-broken-addon.yaml", rootProjectDir), clusterName, kubeconfig, cluster.WithCleanupOnFailure())
+			Expect(err).To(MatchError(ContainSubstring(`bootstrap phase "addons"`)))
+
+			output, err := utils.Run(exec.Command("kind", "get", "clusters"))
+			Expect(err).NotTo(HaveOccurred(), "failed to list kind clusters")
+			Expect(output).NotTo(ContainSubstring(clusterName))
+		})
+		It("should keep the completed phases of a failed bootstrap", func() {
+			err := cluster.CreateKemuCluster(fmt.Sprintf("%s/test/testdata/with-broken-addon.yaml", rootProjectDir), clusterName, kubeconfig)
+			Expect(err).To(MatchError(ContainSubstring("--resume")))
+
+			record, err := cluster.GetClusterRecord(kubeconfig)
+			Expect(err).NotTo(HaveOccurred(), "failed to get cluster config record")
+			Expect(record.Status.CompletedPhases).To(Equal([]string{cluster.PhaseControlPlane, cluster.PhaseImages, cluster.PhaseKWOK}))
+		})
+		It("should resume the bootstrap from the failed phase", func() {
+			err := cluster.CreateKemuCluster(fmt.Sprintf("%s/test/testdata/with-broken-addon.yaml", rootProjectDir), clusterName, kubeconfig)
+			Expect(err).To(MatchError(ContainSubstring("already exists")))
+
+			err = cluster.CreateKemuCluster(fmt.Sprintf("%s/test/testdata/with-kwok-nodes.yaml", rootProjectDir), clusterName, kubeconfig, cluster.WithResume())
+			Expect(err).NotTo(HaveOccurred(), "failed to resume cluster bootstrap")
+
+			record, err := cluster.GetClusterRecord(kubeconfig)
+			Expect(err).NotTo(HaveOccurred(), "failed to get cluster config record")
+			Expect(record.Status.CompletedPhases).To(Equal([]string{cluster.PhaseControlPlane, cluster.PhaseImages, cluster.PhaseKWOK, cluster.PhaseAddons, cluster.PhaseNodes}))
+
+			nodes, err := getClient(kubeconfig).CoreV1().Nodes().List(context.Background(), metav1.ListOptions{LabelSelector: cluster.ManagedByKemuLabel})
+			Expect(err).NotTo(HaveOccurred(), "failed to list nodes")
+			Expect(nodes.Items).To(HaveLen(35))
+		})
+		It("should delete the resumed cluster", func() {
+			deleteCluster(clusterName)
+		})
+	})
+
+	Context("with existing control plane", Ordered, func() {
+		clusterName := "it-with-existing"
+		kubeconfig := fmt.Sprintf("%s/.run/it-with-existing.config", rootProjectDir)
+
+		It("should install KEMU into an existing cluster", func() {
+			_, err := utils.Run(exec.Command("kind", "create", "cluster", "--name", clusterName, "--kubeconfig", kubeconfig))
+			Expect(err).NotTo(HaveOccurred(), "failed to create kind cluster")
+
+			provider, err := cluster.NewExistingProvider(kubeconfig, "kind-"+clusterName)
+			Expect(err).NotTo(HaveOccurred(), "failed to create provider")
+			defer provider.Close()
+
+			err = cluster.CreateKemuCluster(fmt.Sprintf("%s/test/testdata/with-kwok-nodes.yaml", rootProjectDir), clusterName, kubeconfig, cluster.WithProvider(provider))
+			Expect(err).NotTo(HaveOccurred(), "failed to install KEMU into existing cluster")
+
+			nodes, err := getClient(kubeconfig).CoreV1().Nodes().List(context.Background(), metav1.ListOptions{LabelSelector: cluster.ManagedByKemuLabel})
+			Expect(err).NotTo(HaveOccurred(), "failed to list nodes")
+			Expect(nodes.Items).To(HaveLen(35))
+
+			record, err := cluster.GetClusterRecord(kubeconfig)
+			Expect(err).NotTo(HaveOccurred(), "failed to get cluster config record")
+			Expect(record.Status.Provider).To(Equal(cluster.ProviderExisting))
+			Expect(record.Status.Context).To(Equal("kind-" + clusterName))
+		})
+		It("should remove only KEMU resources from the existing cluster", func() {
+			provider, err := cluster.NewExistingProvider(kubeconfig, "")
+			Expect(err).NotTo(HaveOccurred(), "failed to create provider")
+
+			err = cluster.DeleteKemuClusterWithProvider(clusterName, provider)
+			Expect(err).NotTo(HaveOccurred(), "failed to delete KEMU from existing cluster")
+
+			nodes, err := getClient(kubeconfig).CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred(), "failed to list nodes")
+			Expect(nodes.Items).To(HaveLen(1), "expected only the control plane node to remain")
+
+			config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+			Expect(err).NotTo(HaveOccurred(), "failed to build config from kubeconfig")
+			stages, err := kwokclient.NewForConfigOrDie(config).KwokV1alpha1().Stages().List(context.Background(), metav1.ListOptions{LabelSelector: cluster.ManagedByKemuLabel})
+			if !apierrors.IsNotFound(err) {
+				Expect(err).NotTo(HaveOccurred(), "failed to list kwok stages")
+				Expect(stages.Items).To(BeEmpty(), "expected KEMU stages to be deleted")
+			}
+
+			_, err = cluster.GetClusterRecord(kubeconfig)
+			Expect(err).To(MatchError(ContainSubstring("not found")))
+		})
+		It("should delete the existing cluster", func() {
+			deleteCluster(clusterName)
+		})
+	})
+
 	Context("with custom kind config", Ordered, func() {
 		clusterName := "it-with-kind-config"
 
@@ -158,6 +260,94 @@ var _ = Describe("kemu API", func() {
 				Expect(err).NotTo(HaveOccurred(), "failed to list nodes")
 				Expect(len(nodes.Items)).To(Equal(tc.expected), fmt.Sprintf("expected %d nodes, got %d", tc.expected, len(nodes.Items)))
 			}
+
+			serverVersion, err := client.Discovery().ServerVersion()
+			Expect(err).NotTo(HaveOccurred(), "failed to get server version")
+			nodes, err := client.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{LabelSelector: "kubernetes.io/os=linux,type=kwok"})
+			Expect(err).NotTo(HaveOccurred(), "failed to list nodes")
+			Expect(nodes.Items).To(HaveLen(35))
+			Expect(nodes.Items[0].Status.NodeInfo.KubeletVersion).To(Equal(serverVersion.GitVersion))
+
+			record, err := cluster.GetClusterRecord(kubeconfig)
+			Expect(err).NotTo(HaveOccurred(), "failed to get cluster config record")
+			Expect(record.Spec.NodeGroups).To(HaveLen(2))
+			Expect(record.Status).NotTo(BeNil())
+			Expect(record.Status.ClusterName).To(Equal(clusterName))
+			Expect(record.Status.KWOKChartVersion).To(Equal("0.2.0"))
+			Expect(record.Status.Addons).To(HaveLen(2))
+		})
+		It("should describe the KEMU cluster", func() {
+			clusters, err := cluster.ListKemuClusters()
+			Expect(err).NotTo(HaveOccurred(), "failed to list KEMU clusters")
+			Expect(clusters).To(ContainElement(HaveField("Name", clusterName)))
+
+			description, err := cluster.DescribeKemuCluster(clusterName, "")
+			Expect(err).NotTo(HaveOccurred(), "failed to describe cluster")
+			Expect(description.Name).To(Equal(clusterName))
+			Expect(description.NodeGroups).To(HaveLen(5))
+			gpus := int64(0)
+			for _, r := range description.Resources {
+				if r.Name == "nvidia.com/gpu" {
+					gpus = r.Capacity.Value()
+				}
+			}
+			Expect(gpus).To(Equal(int64(280)))
+			Expect(description.Addons).To(HaveLen(2))
+			Expect(description.KWOKController).To(HavePrefix("healthy"))
+		})
+		It("should export the KEMU cluster", func() {
+			config, err := cluster.ExportKemuCluster(clusterName, "")
+			Expect(err).NotTo(HaveOccurred(), "failed to export cluster")
+			Expect(config.Spec.NodeGroups).To(HaveLen(2))
+			Expect(config.Spec.NodeGroups[1].Placement).To(Equal([]api.Placement{
+				{AvailabilityZone: "use1", Replicas: 10},
+				{AvailabilityZone: "use2", Replicas: 10},
+			}))
+			Expect(config.Spec.ClusterAddons).To(ConsistOf(
+				HaveField("Name", "kwok"),
+				HaveField("Name", "kwok-stage-fast"),
+			))
+		})
+		It("should record the timeline of submitted workloads", func() {
+			kubeconfig := fmt.Sprintf("%s/.run/it-with-kwok.config", rootProjectDir)
+			output := filepath.Join(GinkgoT().TempDir(), "run.jsonl")
+			file, err := os.Create(output)
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			recorded := make(chan error)
+			go func() { recorded <- timeline.Record(ctx, timeline.RecordOptions{Kubeconfig: kubeconfig}, file) }()
+
+			err = workload.Submit(workload.SubmitOptions{
+				Template: fmt.Sprintf("%s/examples/workloads/job-h100-az-affinity-20-workers.tmpl", rootProjectDir),
+				Count:    2,
+				Workers:  2,
+				Arrival:  "fixed:1s",
+				Duration: "5s",
+			}, kubeconfig, io.Discard)
+			Expect(err).NotTo(HaveOccurred(), "failed to submit workloads")
+
+			countEvents := func(eventType timeline.EventType) int {
+				events, err := timeline.Read(output)
+				Expect(err).NotTo(HaveOccurred(), "failed to read timeline")
+				return len(slices.DeleteFunc(events, func(e timeline.Event) bool { return e.Type != eventType }))
+			}
+			Eventually(func() int { return countEvents(timeline.JobCompleted) }).
+				WithTimeout(2 * time.Minute).WithPolling(time.Second).Should(Equal(2))
+			cancel()
+			Expect(<-recorded).To(Succeed())
+
+			events, err := timeline.Read(output)
+			Expect(err).NotTo(HaveOccurred(), "failed to read timeline")
+			Expect(countEvents(timeline.NodeAdded)).To(Equal(35))
+			Expect(countEvents(timeline.PodSucceeded)).To(Equal(4))
+			scheduled := slices.DeleteFunc(events, func(e timeline.Event) bool { return e.Type != timeline.PodScheduled })
+			Expect(scheduled).To(HaveLen(4))
+			Expect(scheduled[0].InstanceType).To(Equal("a3-highgpu-8g"))
+			Expect(scheduled[0].NodeGroup).To(Equal("a3-highgpu-8g"))
+			Expect(scheduled[0].Zone).To(BeElementOf("use1", "use2"))
+			Expect(scheduled[0].JobID).NotTo(BeEmpty())
 		})
 		It("should delete created Kind cluster with kwok nodes", func() {
 			deleteCluster(clusterName)
@@ -228,4 +418,127 @@ var _ = Describe("kemu API", func() {
 			deleteCluster(clusterName)
 		})
 	})
+
+	Context("with apply", Ordered, func() {
+		clusterName := "it-with-apply"
+		kubeconfig := fmt.Sprintf("%s/.run/it-with-apply.config", rootProjectDir)
+
+		It("should create a KEMU cluster with kwok nodes", func() {
+			err := cluster.CreateKemuCluster(fmt.Sprintf("%s/test/testdata/with-kwok-nodes.yaml", rootProjectDir), clusterName, kubeconfig)
+			Expect(err).NotTo(HaveOccurred(), "failed to create cluster")
+		})
+		It("should reconcile the cluster with the updated config", func() {
+			// Labels added outside KEMU are kept by apply.
+			client := getClient(kubeconfig)
+			_, err := client.CoreV1().Nodes().Patch(context.Background(), "a2-ultragpu-8g-use1-0", types.MergePatchType,
+				[]byte(`{"metadata":{"labels":{"example.com/owner":"ops"}}}`), metav1.PatchOptions{})
+			Expect(err).NotTo(HaveOccurred(), "failed to label node")
+
+			plan, err := cluster.PlanKemuCluster(fmt.Sprintf("%s/test/testdata/with-kwok-nodes-updated.yaml", rootProjectDir), kubeconfig)
+			Expect(err).NotTo(HaveOccurred(), "failed to plan changes")
+			Expect(plan.NodesToCreate).To(HaveLen(2), "expected 2 nodes to create")
+			Expect(plan.NodesToDelete).To(HaveLen(17), "expected 17 nodes to delete")
+			Expect(plan.NodesToUpdate).To(HaveLen(18), "expected 18 nodes to update")
+
+			err = cluster.ApplyKemuClusterPlan(plan, kubeconfig)
+			Expect(err).NotTo(HaveOccurred(), "failed to apply changes")
+
+			node, err := client.CoreV1().Nodes().Get(context.Background(), "a2-ultragpu-8g-use1-0", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred(), "failed to get node")
+			Expect(node.Labels).To(HaveKeyWithValue("example.com/owner", "ops"))
+			Expect(node.Labels).To(HaveKeyWithValue("datastrophic.io/pool", "training"))
+
+			data := []struct {
+				selector string
+				expected int
+			}{
+				{"node.kubernetes.io/instance-type=a2-ultragpu-8g,topology.kubernetes.io/zone=use1,datastrophic.io/pool=training", 7},
+				{"node.kubernetes.io/instance-type=a2-ultragpu-8g,topology.kubernetes.io/zone=use2,datastrophic.io/pool=training", 3},
+				{"node.kubernetes.io/instance-type=a2-ultragpu-8g,topology.kubernetes.io/zone=use3", 0},
+				{"node.kubernetes.io/instance-type=a3-highgpu-8g,topology.kubernetes.io/zone=use1", 10},
+				{"node.kubernetes.io/instance-type=a3-highgpu-8g,topology.kubernetes.io/zone=use2", 0},
+			}
+			for _, tc := range data {
+				nodes, err := client.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{LabelSelector: tc.selector})
+				Expect(err).NotTo(HaveOccurred(), "failed to list nodes")
+				Expect(len(nodes.Items)).To(Equal(tc.expected), fmt.Sprintf("expected %d nodes for %s, got %d", tc.expected, tc.selector, len(nodes.Items)))
+			}
+
+			plan, err = cluster.PlanKemuCluster(fmt.Sprintf("%s/test/testdata/with-kwok-nodes-updated.yaml", rootProjectDir), kubeconfig)
+			Expect(err).NotTo(HaveOccurred(), "failed to plan changes")
+			Expect(plan.Empty()).To(BeTrue(), "expected no changes after apply")
+
+			record, err := cluster.GetClusterRecord(kubeconfig)
+			Expect(err).NotTo(HaveOccurred(), "failed to get cluster config record")
+			Expect(record.Spec.NodeGroups[0].Placement).To(HaveLen(2))
+			Expect(record.Status.ClusterName).To(Equal(clusterName))
+		})
+		It("should delete created Kind cluster", func() {
+			deleteCluster(clusterName)
+		})
+	})
+
+	Context("with node group scaling", Ordered, func() {
+		clusterName := "it-with-scale"
+		kubeconfig := fmt.Sprintf("%s/.run/it-with-scale.config", rootProjectDir)
+		selector := "node.kubernetes.io/instance-type=a2-ultragpu-8g,topology.kubernetes.io/zone=use1"
+
+		It("should create a KEMU cluster with kwok nodes", func() {
+			err := cluster.CreateKemuCluster(fmt.Sprintf("%s/test/testdata/with-kwok-nodes.yaml", rootProjectDir), clusterName, kubeconfig)
+			Expect(err).NotTo(HaveOccurred(), "failed to create cluster")
+		})
+		It("should scale the node group up", func() {
+			err := cluster.ScaleNodeGroup("a2-ultragpu-8g", "use1", 8, kubeconfig, cluster.ScaleOptions{})
+			Expect(err).NotTo(HaveOccurred(), "failed to scale node group")
+
+			client := getClient(kubeconfig)
+			nodes, err := client.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+			Expect(err).NotTo(HaveOccurred(), "failed to list nodes")
+			Expect(len(nodes.Items)).To(Equal(8), fmt.Sprintf("expected 8 nodes, got %d", len(nodes.Items)))
+
+			node, err := client.CoreV1().Nodes().Get(context.Background(), "a2-ultragpu-8g-use1-7", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred(), "failed to get the new node")
+			Expect(node.Labels).To(HaveKeyWithValue("datastrophic.io/gpu-type", "nvidia-a100-80gb"))
+		})
+		It("should scale the node group into an empty zone after its recorded spec", func() {
+			// Labels of nodes in other zones aren't copied to the new zone.
+			client := getClient(kubeconfig)
+			_, err := client.CoreV1().Nodes().Patch(context.Background(), "a3-highgpu-8g-use1-0", types.MergePatchType,
+				[]byte(`{"metadata":{"labels":{"topology.datastrophic.io/rack":"use1-rack0"}}}`), metav1.PatchOptions{})
+			Expect(err).NotTo(HaveOccurred(), "failed to label node")
+
+			err = cluster.ScaleNodeGroup("a3-highgpu-8g", "use3", 2, kubeconfig, cluster.ScaleOptions{})
+			Expect(err).NotTo(HaveOccurred(), "failed to scale node group")
+
+			nodes, err := client.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{LabelSelector: "node.kubernetes.io/instance-type=a3-highgpu-8g,topology.kubernetes.io/zone=use3"})
+			Expect(err).NotTo(HaveOccurred(), "failed to list nodes")
+			Expect(nodes.Items).To(HaveLen(2))
+			for _, node := range nodes.Items {
+				Expect(node.Name).To(HavePrefix("a3-highgpu-8g-use3-"))
+				Expect(node.Labels).To(HaveKeyWithValue("datastrophic.io/gpu-type", "nvidia-h100-80gb"))
+				Expect(node.Labels).NotTo(HaveKey("topology.datastrophic.io/rack"))
+				Expect(node.Status.Capacity.Cpu().String()).To(Equal("208"))
+			}
+
+			record, err := cluster.GetClusterRecord(kubeconfig)
+			Expect(err).NotTo(HaveOccurred(), "failed to get cluster config record")
+			Expect(record.Spec.NodeGroups[1].Placement).To(ContainElement(api.Placement{AvailabilityZone: "use3", Replicas: 2}))
+		})
+		It("should scale the node group down removing the highest-indexed nodes", func() {
+			err := cluster.ScaleNodeGroup("a2-ultragpu-8g", "use1", 2, kubeconfig, cluster.ScaleOptions{Drain: true})
+			Expect(err).NotTo(HaveOccurred(), "failed to scale node group")
+
+			client := getClient(kubeconfig)
+			nodes, err := client.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+			Expect(err).NotTo(HaveOccurred(), "failed to list nodes")
+			var names []string
+			for _, node := range nodes.Items {
+				names = append(names, node.Name)
+			}
+			Expect(names).To(ConsistOf("a2-ultragpu-8g-use1-0", "a2-ultragpu-8g-use1-1"))
+		})
+		It("should delete created Kind cluster", func() {
+			deleteCluster(clusterName)
+		})
+	})
 })
@@ -0,0 +1,209 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/datastrophic/kemu/pkg/api"
+	"github.com/datastrophic/kemu/pkg/cluster"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// fakeNodeServer is an API server accepting node creation with responses
+// chosen per node and attempt.
+type fakeNodeServer struct {
+	*httptest.Server
+	// respond returns the status of the attempt to create the node, nodes are
+	// created on http.StatusCreated.
+	respond func(name string, attempt int) (int, metav1.StatusReason)
+
+	mu          sync.Mutex
+	attempts    map[string]int
+	created     []string
+	inFlight    int
+	maxInFlight int
+	delay       time.Duration
+}
+
+func newFakeNodeServer() *fakeNodeServer {
+	s := &fakeNodeServer{
+		attempts: make(map[string]int),
+		respond:  func(string, int) (int, metav1.StatusReason) { return http.StatusCreated, "" },
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	DeferCleanup(s.Close)
+	return s
+}
+
+func (s *fakeNodeServer) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet && r.URL.Path == "/version" {
+		_ = json.NewEncoder(w).Encode(map[string]string{"gitVersion": "v1.33.1"})
+		return
+	}
+	if r.Method != http.MethodPost || r.URL.Path != "/api/v1/nodes" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Clients send protobuf, which is decoded by the client scheme.
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	object, _, err := scheme.Codecs.UniversalDeserializer().Decode(body, nil, nil)
+	node, ok := object.(*corev1.Node)
+	if err != nil || !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.attempts[node.Name]++
+	attempt := s.attempts[node.Name]
+	s.inFlight++
+	s.maxInFlight = max(s.maxInFlight, s.inFlight)
+	s.mu.Unlock()
+
+	time.Sleep(s.delay)
+	code, reason := s.respond(node.Name, attempt)
+
+	s.mu.Lock()
+	s.inFlight--
+	if code == http.StatusCreated {
+		s.created = append(s.created, node.Name)
+	}
+	s.mu.Unlock()
+
+	w.WriteHeader(code)
+	if code == http.StatusCreated {
+		_ = json.NewEncoder(w).Encode(node)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Message:  fmt.Sprintf("node %q: %s", node.Name, reason),
+		Reason:   reason,
+		Code:     int32(code),
+	})
+}
+
+// kubeconfig writes a kubeconfig for the server to a temporary file.
+func (s *fakeNodeServer) kubeconfig() string {
+	path := filepath.Join(GinkgoT().TempDir(), "kubeconfig")
+	config := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: fake
+  cluster:
+    server: %s
+contexts:
+- name: fake
+  context:
+    cluster: fake
+    user: fake
+current-context: fake
+users:
+- name: fake
+  user: {}
+`, s.URL)
+	Expect(os.WriteFile(path, []byte(config), 0o600)).To(Succeed())
+	return path
+}
+
+var _ = Describe("node creation", func() {
+	var server *fakeNodeServer
+	BeforeEach(func() {
+		server = newFakeNodeServer()
+	})
+
+	nodeGroups := func(replicas int) []api.NodeGroup {
+		return []api.NodeGroup{{
+			Name:      "gpu",
+			Placement: []api.Placement{{AvailabilityZone: "use1", Replicas: replicas}},
+		}}
+	}
+
+	It("should create nodes with a bounded number of workers", func() {
+		server.delay = 20 * time.Millisecond
+		settings := api.NodeCreation{Concurrency: 3, QPS: 1000, Burst: 1000}
+		Expect(cluster.CreateClusterNodes(nodeGroups(20), settings, server.kubeconfig())).To(Succeed())
+
+		Expect(server.created).To(HaveLen(20))
+		Expect(server.maxInFlight).To(BeNumerically("<=", 3))
+		Expect(server.maxInFlight).To(BeNumerically(">", 1), "expected nodes to be created in parallel")
+	})
+
+	It("should limit the rate of requests", func() {
+		settings := api.NodeCreation{Concurrency: 8, QPS: 20, Burst: 1}
+		start := time.Now()
+		Expect(cluster.CreateClusterNodes(nodeGroups(6), settings, server.kubeconfig())).To(Succeed())
+
+		// The version request takes the burst, so the 6 nodes wait 1/QPS each.
+		Expect(time.Since(start)).To(BeNumerically(">=", 250*time.Millisecond))
+		Expect(server.created).To(HaveLen(6))
+	})
+
+	It("should retry throttled, timed out, and conflicting requests", func() {
+		server.respond = func(name string, attempt int) (int, metav1.StatusReason) {
+			switch {
+			case attempt == 1 && name == "gpu-use1-0":
+				return http.StatusTooManyRequests, metav1.StatusReasonTooManyRequests
+			case attempt == 1 && name == "gpu-use1-1":
+				return http.StatusGatewayTimeout, metav1.StatusReasonTimeout
+			case attempt <= 2 && name == "gpu-use1-2":
+				return http.StatusServiceUnavailable, metav1.StatusReasonServiceUnavailable
+			case attempt == 1 && name == "gpu-use1-3":
+				return http.StatusConflict, metav1.StatusReasonConflict
+			}
+			return http.StatusCreated, ""
+		}
+		Expect(cluster.CreateClusterNodes(nodeGroups(4), api.NodeCreation{}, server.kubeconfig())).To(Succeed())
+
+		Expect(server.created).To(ConsistOf("gpu-use1-0", "gpu-use1-1", "gpu-use1-2", "gpu-use1-3"))
+		Expect(server.attempts).To(Equal(map[string]int{"gpu-use1-0": 2, "gpu-use1-1": 2, "gpu-use1-2": 3, "gpu-use1-3": 2}))
+	})
+
+	It("should treat existing nodes as created", func() {
+		server.respond = func(name string, attempt int) (int, metav1.StatusReason) {
+			if name == "gpu-use1-1" {
+				return http.StatusConflict, metav1.StatusReasonAlreadyExists
+			}
+			return http.StatusCreated, ""
+		}
+		Expect(cluster.CreateClusterNodes(nodeGroups(3), api.NodeCreation{}, server.kubeconfig())).To(Succeed())
+		Expect(server.attempts).To(HaveKeyWithValue("gpu-use1-1", 1))
+	})
+
+	It("should report failed nodes without retrying other errors", func() {
+		server.respond = func(name string, attempt int) (int, metav1.StatusReason) {
+			switch name {
+			case "gpu-use1-0":
+				return http.StatusUnprocessableEntity, metav1.StatusReasonInvalid
+			case "gpu-use1-2":
+				return http.StatusForbidden, metav1.StatusReasonForbidden
+			}
+			return http.StatusCreated, ""
+		}
+		err := cluster.CreateClusterNodes(nodeGroups(4), api.NodeCreation{}, server.kubeconfig())
+		Expect(err).To(MatchError(ContainSubstring("failed to create 2 of 4 nodes")))
+		Expect(err).To(MatchError(ContainSubstring(`node "gpu-use1-0"`)))
+		Expect(err).To(MatchError(ContainSubstring(`node "gpu-use1-2"`)))
+
+		Expect(server.created).To(ConsistOf("gpu-use1-1", "gpu-use1-3"))
+		Expect(server.attempts).To(HaveKeyWithValue("gpu-use1-0", 1))
+		Expect(server.attempts).To(HaveKeyWithValue("gpu-use1-2", 1))
+	})
+})
@@ -0,0 +1,283 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/datastrophic/kemu/pkg/cluster"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	kwok "sigs.k8s.io/kwok/pkg/apis/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+// renderedNodes renders the cluster config with assertions and returns the
+// rendered nodes.
+func renderedNodes(config string) []corev1.Node {
+	var out bytes.Buffer
+	err := cluster.RenderKemuCluster(config, cluster.RenderFormatYAML, &out)
+	Expect(err).NotTo(HaveOccurred(), "failed to render cluster")
+
+	var nodes []corev1.Node
+	for _, document := range strings.Split(out.String(), "---\n") {
+		var node corev1.Node
+		Expect(yaml.Unmarshal([]byte(document), &node)).To(Succeed())
+		if node.Kind == "Node" {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// renderedStages renders the cluster config with assertions and returns the
+// rendered KWOK stages.
+func renderedStages(config string) []kwok.Stage {
+	var out bytes.Buffer
+	err := cluster.RenderKemuCluster(config, cluster.RenderFormatYAML, &out)
+	Expect(err).NotTo(HaveOccurred(), "failed to render cluster")
+
+	var stages []kwok.Stage
+	for _, document := range strings.Split(out.String(), "---\n") {
+		var stage kwok.Stage
+		Expect(yaml.Unmarshal([]byte(document), &stage)).To(Succeed())
+		if stage.Kind == kwok.StageKind {
+			stages = append(stages, stage)
+		}
+	}
+	return stages
+}
+
+var _ = Describe("cluster rendering", func() {
+	var config string
+	BeforeEach(func() {
+		config = fmt.Sprintf("%s/test/testdata/with-kwok-nodes.yaml", rootProjectDir)
+	})
+
+	It("should render nodes and the bootstrap plan as multi-document YAML", func() {
+		var out bytes.Buffer
+		err := cluster.RenderKemuCluster(config, cluster.RenderFormatYAML, &out)
+		Expect(err).NotTo(HaveOccurred(), "failed to render cluster")
+
+		documents := strings.Split(out.String(), "---\n")
+		Expect(documents).To(HaveLen(39))
+
+		var plan corev1.ConfigMap
+		Expect(yaml.Unmarshal([]byte(documents[0]), &plan)).To(Succeed())
+		Expect(plan.Name).To(Equal(cluster.RenderedPlanName))
+		Expect(plan.Data["kind-config.yaml"]).To(ContainSubstring("kind: Cluster"))
+		Expect(plan.Data["addons.yaml"]).To(ContainSubstring("chart: kwok/stage-fast"))
+
+		var node corev1.Node
+		Expect(yaml.Unmarshal([]byte(documents[4]), &node)).To(Succeed())
+		Expect(node.Kind).To(Equal("Node"))
+		Expect(node.Name).To(Equal("a2-ultragpu-8g-use1-0"))
+		Expect(node.Labels).To(HaveKeyWithValue(cluster.ZoneLabel, "use1"))
+		Expect(node.Status.Capacity.Name("nvidia.com/gpu", "").Value()).To(Equal(int64(8)))
+	})
+
+	It("should render identical output for the same config", func() {
+		var first, second bytes.Buffer
+		Expect(cluster.RenderKemuCluster(config, cluster.RenderFormatYAML, &first)).To(Succeed())
+		Expect(cluster.RenderKemuCluster(config, cluster.RenderFormatYAML, &second)).To(Succeed())
+		Expect(first.String()).To(Equal(second.String()))
+	})
+
+	It("should render embedded KWOK charts by default", func() {
+		var out bytes.Buffer
+		err := cluster.RenderKemuCluster(fmt.Sprintf("%s/test/testdata/simple.yaml", rootProjectDir), cluster.RenderFormatYAML, &out)
+		Expect(err).NotTo(HaveOccurred(), "failed to render cluster")
+		Expect(out.String()).To(ContainSubstring("chart: embedded://kwok"))
+		Expect(out.String()).To(ContainSubstring("chart: embedded://stage-fast"))
+		Expect(out.String()).NotTo(ContainSubstring("https://"))
+	})
+
+	It("should render the common stages for the default KWOK profile", func() {
+		var names []string
+		for _, stage := range renderedStages(config) {
+			names = append(names, stage.Name)
+		}
+		Expect(names).To(Equal([]string{"pod-restart", "pod-fail", "pod-complete-standalone"}))
+
+		var out bytes.Buffer
+		Expect(cluster.RenderKemuCluster(config, cluster.RenderFormatYAML, &out)).To(Succeed())
+		Expect(out.String()).To(ContainSubstring(cluster.PodFailProbabilityAnnotation))
+		Expect(out.String()).To(ContainSubstring(cluster.PodRestartCountAnnotation))
+	})
+
+	It("should render the stages of the KWOK profile with custom stages", func() {
+		config := fmt.Sprintf("%s/test/testdata/with-kwok-profile.yaml", rootProjectDir)
+		var out bytes.Buffer
+		err := cluster.RenderKemuCluster(config, cluster.RenderFormatYAML, &out)
+		Expect(err).NotTo(HaveOccurred(), "failed to render cluster")
+		Expect(out.String()).NotTo(ContainSubstring("stage-fast"))
+
+		stages := make(map[string]kwok.Stage)
+		for _, stage := range renderedStages(config) {
+			stages[stage.Name] = stage
+		}
+		Expect(stages).To(HaveKey("node-initialize"))
+		Expect(stages).To(HaveKey("pod-fail"))
+		Expect(stages).To(HaveKey("pod-fail-random"))
+		Expect(stages["pod-complete"].Spec.Weight).To(Equal(9))
+		Expect(stages["pod-ready"].Spec.Delay.DurationMilliseconds).To(HaveValue(Equal(int64(5000))))
+		Expect(stages["pod-ready"].Labels).To(HaveKeyWithValue(cluster.ManagedByKemuLabel, "true"))
+	})
+
+	It("should render node taints and annotations", func() {
+		nodes := make(map[string]corev1.Node)
+		for _, node := range renderedNodes(fmt.Sprintf("%s/test/testdata/with-taints.yaml", rootProjectDir)) {
+			nodes[node.Name] = node
+		}
+		Expect(nodes).To(HaveLen(3))
+		Expect(nodes["a3-highgpu-8g-use1-0"].Spec.Taints).To(Equal([]corev1.Taint{
+			{Key: "kwok.x-k8s.io/node", Value: "fake", Effect: corev1.TaintEffectNoSchedule},
+			{Key: "nvidia.com/gpu", Value: "present", Effect: corev1.TaintEffectNoSchedule},
+			{Key: "datastrophic.io/tenant", Value: "research", Effect: corev1.TaintEffectNoExecute},
+		}))
+		Expect(nodes["a3-highgpu-8g-use1-1"].Annotations).To(Equal(map[string]string{
+			"kwok.x-k8s.io/node":                        "fake",
+			"datastrophic.io/hourly-cost":               "88.49",
+			"topology.datastrophic.io/block":            "b1",
+			"kemu.datastrophic.io/template-annotations": "datastrophic.io/hourly-cost,topology.datastrophic.io/block",
+		}))
+		Expect(nodes["n2-standard-8-spot-use1-0"].Annotations).To(Equal(map[string]string{"kwok.x-k8s.io/node": "fake"}))
+		Expect(nodes["n2-standard-8-spot-use1-0"].Spec.Taints).To(Equal([]corev1.Taint{
+			{Key: "cloud.google.com/gke-spot", Value: "true", Effect: corev1.TaintEffectPreferNoSchedule},
+		}))
+	})
+
+	It("should derive allocatable resources from reservations", func() {
+		allocatable := make(map[string]corev1.ResourceList)
+		for _, node := range renderedNodes(fmt.Sprintf("%s/test/testdata/with-reservations.yaml", rootProjectDir)) {
+			allocatable[node.Labels[cluster.NodeGroupLabel]] = node.Status.Allocatable
+		}
+
+		expected := map[string]map[corev1.ResourceName]string{
+			// GKE: 90m CPU and 3.56Gi of memory are reserved, plus the 100Mi eviction threshold.
+			"n2-standard-8": {corev1.ResourceCPU: "7910m", corev1.ResourceMemory: "30432359875", corev1.ResourcePods: "110"},
+			// EKS: 255Mi + 11Mi per pod of memory, with the eviction threshold overridden.
+			"m5-2xlarge":           {corev1.ResourceCPU: "7910m", corev1.ResourceMemory: "31675Mi", corev1.ResourcePods: "58"},
+			"custom-reserved":      {corev1.ResourceCPU: "15", corev1.ResourceMemory: "62Gi", corev1.ResourceEphemeralStorage: "90Gi"},
+			"explicit-allocatable": {corev1.ResourceCPU: "15", corev1.ResourceMemory: "64Gi"},
+		}
+		Expect(allocatable).To(HaveLen(len(expected)))
+		for group, resources := range expected {
+			Expect(allocatable[group]).To(HaveLen(len(resources)), group)
+			for name, quantity := range resources {
+				actual := allocatable[group][name]
+				Expect(actual.Cmp(resource.MustParse(quantity))).To(Equal(0), fmt.Sprintf("%s %s: expected %s, got %s", group, name, quantity, actual.String()))
+			}
+		}
+	})
+
+	It("should render node system info", func() {
+		nodes := make(map[string]corev1.Node)
+		for _, node := range renderedNodes(fmt.Sprintf("%s/test/testdata/with-node-info.yaml", rootProjectDir)) {
+			nodes[node.Labels[cluster.NodeGroupLabel]] = node
+		}
+
+		arm := nodes["c4a-standard-16"]
+		Expect(arm.Labels).To(HaveKeyWithValue("kubernetes.io/arch", "arm64"))
+		Expect(arm.Labels).To(HaveKeyWithValue("kubernetes.io/os", "linux"))
+		Expect(arm.Status.NodeInfo.KubeletVersion).To(Equal("v1.32.4"))
+		Expect(arm.Status.NodeInfo.KernelVersion).To(Equal("6.6.72+"))
+		Expect(arm.Status.NodeInfo.ContainerRuntimeVersion).To(Equal("containerd://1.7.24"))
+
+		defaults := nodes["n2-standard-16"]
+		Expect(defaults.Labels).To(HaveKeyWithValue("kubernetes.io/arch", cluster.DefaultNodeArchitecture))
+		Expect(defaults.Labels).To(HaveKeyWithValue("kubernetes.io/os", cluster.DefaultNodeOperatingSystem))
+		Expect(defaults.Status.NodeInfo.KubeletVersion).To(Equal(cluster.DefaultKubeletVersion))
+	})
+
+	It("should fill in capacity and labels from the instance type catalog", func() {
+		nodes := make(map[string]corev1.Node)
+		for _, node := range renderedNodes(fmt.Sprintf("%s/test/testdata/with-instance-types.yaml", rootProjectDir)) {
+			nodes[node.Labels[cluster.NodeGroupLabel]] = node
+		}
+
+		gcp := nodes["a3-highgpu-8g"]
+		Expect(gcp.Status.Capacity).To(Equal(corev1.ResourceList{
+			corev1.ResourceCPU:              resource.MustParse("208"),
+			corev1.ResourceMemory:           resource.MustParse("1872Gi"),
+			corev1.ResourceEphemeralStorage: resource.MustParse("6Ti"),
+			corev1.ResourcePods:             resource.MustParse("110"),
+			"nvidia.com/gpu":                resource.MustParse("8"),
+		}))
+		Expect(gcp.Labels).To(HaveKeyWithValue(cluster.InstanceTypeLabel, "a3-highgpu-8g"))
+		Expect(gcp.Labels).To(HaveKeyWithValue("cloud.google.com/gke-accelerator", "nvidia-h100-80gb"))
+		Expect(gcp.Labels).To(HaveKeyWithValue("nvidia.com/gpu.product", "NVIDIA-H100-80GB-HBM3"))
+		Expect(gcp.Labels).To(HaveKeyWithValue("nvidia.com/gpu.count", "8"))
+
+		// The node template overrides the catalog.
+		aws := nodes["p5-training"]
+		Expect(aws.Labels).To(HaveKeyWithValue(cluster.InstanceTypeLabel, "p5.48xlarge"))
+		Expect(aws.Labels).To(HaveKeyWithValue("karpenter.k8s.aws/instance-family", "p5-custom"))
+		Expect(aws.Status.Capacity.Pods().String()).To(Equal("250"))
+		Expect(aws.Status.Capacity.Memory().String()).To(Equal("2Ti"))
+	})
+
+	It("should spread node group replicas across zones", func() {
+		counts := make(map[string]int)
+		for _, node := range renderedNodes(fmt.Sprintf("%s/test/testdata/with-zone-spread.yaml", rootProjectDir)) {
+			counts[node.Labels[cluster.NodeGroupLabel]+"/"+node.Labels[cluster.ZoneLabel]]++
+		}
+		Expect(counts).To(Equal(map[string]int{
+			// Even spread: the remainder goes to the first zone.
+			"n2-standard-8/use1": 4,
+			"n2-standard-8/use2": 3,
+			"n2-standard-8/use3": 3,
+			// 50/30/20 of 7 is 3.5/2.1/1.4: the largest remainder gets the last node.
+			"a3-highgpu-8g/use1": 4,
+			"a3-highgpu-8g/use2": 2,
+			"a3-highgpu-8g/use3": 1,
+		}))
+	})
+
+	It("should label and name nodes after the topology hierarchy", func() {
+		nodes := make(map[string]corev1.Node)
+		blocks := make(map[string]int)
+		cliques := make(map[string]int)
+		for _, node := range renderedNodes(fmt.Sprintf("%s/test/testdata/with-topology.yaml", rootProjectDir)) {
+			nodes[node.Name] = node
+			if block, found := node.Labels["cloud.google.com/gce-topology-block"]; found {
+				blocks[block]++
+			}
+			if clique, found := node.Labels["nvidia.com/gpu.clique"]; found {
+				cliques[clique]++
+			}
+		}
+		Expect(nodes).To(HaveLen(114))
+
+		node, found := nodes["a3-megagpu-8g-use1-block2-subblock1-1"]
+		Expect(found).To(BeTrue(), "expected the 36th node to be the second node of subblock 1 of block 2")
+		Expect(node.Labels).To(HaveKeyWithValue(cluster.RegionLabel, "us-east1"))
+		Expect(node.Labels).To(HaveKeyWithValue(cluster.ZoneLabel, "use1"))
+		Expect(node.Labels).To(HaveKeyWithValue("cloud.google.com/gce-topology-block", "use1-block2"))
+		Expect(node.Labels).To(HaveKeyWithValue("cloud.google.com/gce-topology-subblock", "use1-block2-subblock1"))
+		Expect(node.Labels).To(HaveKeyWithValue(cluster.HostnameLabel, node.Name))
+
+		// Nodes fill the domains in order, leaving the trailing ones partially filled.
+		Expect(blocks).To(Equal(map[string]int{"use1-block0": 16, "use1-block1": 16, "use1-block2": 16, "use1-block3": 12}))
+		Expect(cliques).To(Equal(map[string]int{"use1-rack0": 18, "use1-rack1": 9, "use2-rack0": 18, "use2-rack1": 9}))
+	})
+
+	It("should render a JSON list", func() {
+		var out bytes.Buffer
+		err := cluster.RenderKemuCluster(config, cluster.RenderFormatJSON, &out)
+		Expect(err).NotTo(HaveOccurred(), "failed to render cluster")
+
+		var list corev1.List
+		Expect(json.Unmarshal(out.Bytes(), &list)).To(Succeed())
+		Expect(list.Kind).To(Equal("List"))
+		Expect(list.Items).To(HaveLen(39))
+	})
+
+	It("should reject unsupported output formats", func() {
+		err := cluster.RenderKemuCluster(config, "toml", &bytes.Buffer{})
+		Expect(err).To(MatchError(ContainSubstring("unsupported output format")))
+	})
+})
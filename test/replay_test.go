@@ -0,0 +1,181 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/datastrophic/kemu/pkg/cluster"
+	"github.com/datastrophic/kemu/pkg/workload"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kwokinternal "sigs.k8s.io/kwok/pkg/apis/internalversion"
+	"sigs.k8s.io/kwok/pkg/utils/lifecycle"
+)
+
+// possibleStages returns a function listing the names of the KWOK stages
+// rendered for the config that the pod can move to next.
+func possibleStages(config string) func(pod *corev1.Pod) []string {
+	var stages []*kwokinternal.Stage
+	for _, stage := range renderedStages(config) {
+		internal, err := kwokinternal.ConvertToInternalStage(&stage)
+		Expect(err).NotTo(HaveOccurred(), "failed to convert stage")
+		stages = append(stages, internal)
+	}
+	lc, err := lifecycle.NewLifecycle(stages)
+	Expect(err).NotTo(HaveOccurred(), "failed to create lifecycle")
+
+	return func(pod *corev1.Pod) []string {
+		possible, err := lc.ListAllPossible(context.Background(), &lifecycle.Event{Labels: pod.Labels, Annotations: pod.Annotations, Data: pod})
+		Expect(err).NotTo(HaveOccurred(), "failed to match stages")
+		var names []string
+		for _, stage := range possible {
+			names = append(names, stage.Name())
+		}
+		return names
+	}
+}
+
+var _ = Describe("trace replay", func() {
+	var options workload.ReplayOptions
+	BeforeEach(func() {
+		options = workload.ReplayOptions{Speedup: 60, RunID: "test"}
+	})
+
+	trace := func(name, format string) []workload.TraceRecord {
+		records, err := workload.ReadTrace(fmt.Sprintf("%s/test/testdata/traces/%s", rootProjectDir, name), format)
+		Expect(err).NotTo(HaveOccurred(), "failed to read trace")
+		return records
+	}
+
+	It("should replay CSV traces on a compressed schedule", func() {
+		submissions, err := workload.PlanReplay(trace("jobs.csv", workload.TraceFormatCSV), options)
+		Expect(err).NotTo(HaveOccurred(), "failed to plan replay")
+		Expect(submissions).To(HaveLen(3))
+
+		var ids, durations []string
+		var offsets []time.Duration
+		for _, submission := range submissions {
+			ids = append(ids, submission.TraceID)
+			durations = append(durations, submission.Params.Duration)
+			offsets = append(offsets, submission.Offset)
+		}
+		Expect(ids).To(Equal([]string{"train-1", "train-2", "eval-1"}))
+		Expect(offsets).To(Equal([]time.Duration{0, time.Second, 2 * time.Second}))
+		Expect(durations).To(Equal([]string{"1m0s", "2m0s", "10s"}))
+
+		var job batchv1.Job
+		Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(submissions[0].Object.Object, &job)).To(Succeed())
+		Expect(job.GenerateName).To(Equal("replay-test-0-"))
+		Expect(*job.Spec.Completions).To(BeEquivalentTo(4))
+		Expect(*job.Spec.Parallelism).To(BeEquivalentTo(4))
+		pod := job.Spec.Template
+		Expect(pod.Annotations).To(HaveKeyWithValue(cluster.PodCompleteDelayAnnotation, "1m0s"))
+		Expect(pod.Labels).To(Equal(map[string]string{
+			workload.JobIDLabel:   "replay-test-0",
+			workload.TraceIDLabel: "train-1",
+			workload.QueueLabel:   "research",
+			workload.TenantLabel:  "team-a",
+		}))
+		Expect(pod.Spec.PriorityClassName).To(Equal("high"))
+		Expect(pod.Spec.Containers[0].Resources.Limits).To(Equal(corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("24"),
+			corev1.ResourceMemory: resource.MustParse("200Gi"),
+			"nvidia.com/gpu":      resource.MustParse("8"),
+		}))
+
+		// Single-worker jobs are replayed as Pods.
+		Expect(submissions[2].Object.GetKind()).To(Equal("Pod"))
+		Expect(submissions[2].Object.GetAnnotations()).To(HaveKeyWithValue(cluster.PodCompleteDelayAnnotation, "10s"))
+
+		// Durations compressed below a second keep millisecond precision.
+		options.Speedup = 3600
+		submissions, err = workload.PlanReplay(trace("jobs.csv", workload.TraceFormatCSV), options)
+		Expect(err).NotTo(HaveOccurred(), "failed to plan replay")
+		Expect(submissions[2].Params.Duration).To(Equal("167ms"))
+	})
+
+	It("should replay JSONL traces with timestamps and labels", func() {
+		options.Kind = workload.ReplayKindJob
+		options.QueueLabel = "kueue.x-k8s.io/queue-name"
+		submissions, err := workload.PlanReplay(trace("jobs.jsonl", workload.TraceFormat("jobs.jsonl")), options)
+		Expect(err).NotTo(HaveOccurred(), "failed to plan replay")
+		Expect(submissions).To(HaveLen(2))
+		Expect(submissions[1].Offset).To(Equal(2 * time.Second))
+		Expect(submissions[1].Object.GetKind()).To(Equal("Job"))
+		Expect(submissions[0].Object.GetLabels()).To(HaveKeyWithValue("datastrophic.io/workload", "llm-training"))
+		Expect(submissions[0].Object.GetLabels()).NotTo(HaveKey("kueue.x-k8s.io/queue-name"))
+
+		options.Kind = workload.ReplayKindPod
+		_, err = workload.PlanReplay(trace("jobs.jsonl", workload.TraceFormatJSONL), options)
+		Expect(err).To(MatchError(ContainSubstring("trace record train-1 has 4 workers and can't be replayed as a Pod")))
+	})
+
+	It("should read the Alibaba GPU cluster trace", func() {
+		records := trace("alibaba/pai_task_table.csv", workload.TraceFormatAlibaba)
+		Expect(records).To(HaveLen(3))
+
+		worker := records[0]
+		Expect(worker.ID).To(Equal("7a2b3c4d5e6f708192a3b4c5-worker"))
+		Expect(worker.Workers).To(Equal(8))
+		// Tasks are submitted with their job and last as long as they ran.
+		Expect(worker.Submit).To(BeNumerically("==", 5039700))
+		Expect(worker.Duration).To(Equal(6 * time.Hour))
+		Expect(worker.Tenant).To(Equal("84d4b5d2b0a7"))
+		Expect(worker.GPUType).To(Equal("V100"))
+		Expect(worker.Requests.Cpu().String()).To(Equal("12"))
+		Expect(worker.Requests.Memory().String()).To(Equal("100G"))
+		Expect(worker.Requests.Name("nvidia.com/gpu", resource.DecimalSI).String()).To(Equal("8"))
+		Expect(records[1].Requests).NotTo(HaveKey(corev1.ResourceName("nvidia.com/gpu")))
+
+		// Fractional GPUs are rounded up.
+		tensorflow := records[2]
+		Expect(tensorflow.Submit - worker.Submit).To(BeNumerically("==", 400))
+		Expect(tensorflow.Requests.Name("nvidia.com/gpu", resource.DecimalSI).String()).To(Equal("1"))
+		Expect(tensorflow.Requests.Memory().String()).To(Equal("29297M"))
+
+		options.Speedup = 1
+		submissions, err := workload.PlanReplay(records, options)
+		Expect(err).NotTo(HaveOccurred(), "failed to plan replay")
+		Expect(submissions[0].Object.GetLabels()).To(HaveKeyWithValue(workload.GPUTypeLabel, "V100"))
+		Expect(submissions[2].Offset).To(Equal(400 * time.Second))
+	})
+
+	It("should complete replayed Pods and Job pods with the KWOK stages", func() {
+		submissions, err := workload.PlanReplay(trace("jobs.csv", workload.TraceFormatCSV), options)
+		Expect(err).NotTo(HaveOccurred(), "failed to plan replay")
+
+		var pod corev1.Pod
+		Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(submissions[2].Object.Object, &pod)).To(Succeed())
+		pod.Status.Phase = corev1.PodRunning
+		var job batchv1.Job
+		Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(submissions[0].Object.Object, &job)).To(Succeed())
+		worker := corev1.Pod{ObjectMeta: job.Spec.Template.ObjectMeta, Spec: job.Spec.Template.Spec, Status: corev1.PodStatus{Phase: corev1.PodRunning}}
+		worker.OwnerReferences = []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: "replay-test-0-abcde"}}
+
+		for _, config := range []string{"with-kwok-nodes.yaml", "with-kwok-profile.yaml"} {
+			stages := possibleStages(fmt.Sprintf("%s/test/testdata/%s", rootProjectDir, config))
+			Expect(stages(&pod)).To(ContainElement("pod-complete-standalone"), config)
+			Expect(stages(&worker)).NotTo(ContainElement("pod-complete-standalone"), config)
+		}
+		// Job pods are completed by the pod-complete stage of the profile.
+		stages := possibleStages(fmt.Sprintf("%s/test/testdata/with-kwok-profile.yaml", rootProjectDir))
+		Expect(stages(&worker)).To(ContainElement("pod-complete"))
+	})
+
+	It("should reject invalid traces", func() {
+		_, err := workload.ReadTrace(fmt.Sprintf("%s/test/testdata/traces/jobs.csv", rootProjectDir), "")
+		Expect(err).To(MatchError(ContainSubstring("unsupported trace format")))
+		_, err = workload.ReadTrace(fmt.Sprintf("%s/test/testdata/traces/alibaba/pai_task_table.csv", rootProjectDir), workload.TraceFormatCSV)
+		Expect(err).To(MatchError(ContainSubstring("unknown column")))
+
+		options.Speedup = 0
+		_, err = workload.PlanReplay(nil, options)
+		Expect(err).To(MatchError(ContainSubstring("speedup must be a positive number")))
+	})
+})
@@ -0,0 +1,154 @@
+package test
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/datastrophic/kemu/pkg/report"
+	"github.com/datastrophic/kemu/pkg/timeline"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("experiment report", func() {
+	var r report.Report
+	BeforeEach(func() {
+		events, err := timeline.Read(fmt.Sprintf("%s/test/testdata/timeline.jsonl", rootProjectDir))
+		Expect(err).NotTo(HaveOccurred(), "failed to read timeline")
+		r = report.Compute(events, time.Time{})
+	})
+
+	allocation := func(scope, name, resource string) report.Allocation {
+		for _, a := range r.Allocation {
+			if a.Scope == scope && a.Name == name && a.Resource == resource {
+				return a
+			}
+		}
+		Fail(fmt.Sprintf("no %s allocation of %s %q", resource, scope, name))
+		return report.Allocation{}
+	}
+
+	It("should compute queueing delays and completion times", func() {
+		Expect(r.End.Sub(r.Start)).To(Equal(100 * time.Second))
+		Expect(r.Pods).To(Equal(report.Counts{Created: 3, Scheduled: 3, Succeeded: 3}))
+		Expect(r.Jobs).To(Equal(report.Counts{Created: 2, Scheduled: 2, Succeeded: 2}))
+
+		Expect(r.PodQueueingDelay.Count).To(Equal(3))
+		Expect(r.PodQueueingDelay.Mean).To(BeNumerically("~", 23.333, 0.001))
+		Expect(r.PodQueueingDelay.P50).To(BeNumerically("==", 20))
+		Expect(r.PodQueueingDelay.P90).To(BeNumerically("~", 36, 1e-9))
+		Expect(r.PodQueueingDelay.Max).To(BeNumerically("==", 40))
+
+		// A job is queued until all of its workers are scheduled.
+		Expect(r.JobQueueingDelay.Count).To(Equal(2))
+		Expect(r.JobQueueingDelay.P50).To(BeNumerically("==", 30))
+		Expect(r.JobCompletionTime.P50).To(BeNumerically("==", 66))
+		Expect(r.JobCompletionTime.Max).To(BeNumerically("==", 70))
+	})
+
+	It("should compute time-weighted allocation per zone and node group", func() {
+		gpus := allocation(report.ScopeCluster, "", report.ResourceGPU)
+		Expect(gpus.Allocatable).To(BeNumerically("==", 16))
+		Expect(gpus.Allocated).To(BeNumerically("~", 8.4, 1e-9))
+		Expect(gpus.Utilization).To(BeNumerically("~", 0.525, 1e-9))
+
+		Expect(allocation(report.ScopeCluster, "", report.ResourceCPU).Utilization).To(BeNumerically("~", 0.21, 1e-9))
+		Expect(allocation(report.ScopeZone, "zone-a", report.ResourceGPU).Utilization).To(BeNumerically("~", 0.65, 1e-9))
+		Expect(allocation(report.ScopeZone, "zone-a", report.ResourceCPU).Allocatable).To(BeNumerically("==", 12))
+		Expect(allocation(report.ScopeNodeGroup, "cpu", report.ResourceCPU).Utilization).To(BeZero())
+		Expect(allocation(report.ScopeNodeGroup, "gpu", report.ResourceMemory).Allocatable).To(BeNumerically("==", 128*1024*1024*1024))
+	})
+
+	It("should compute GPU fragmentation excluding cordoned nodes", func() {
+		Expect(r.GPUFragmentation.FreeGPUs).To(BeNumerically("~", 6, 1e-9))
+		Expect(r.GPUFragmentation.FragmentedGPUs).To(BeNumerically("~", 1.2, 1e-9))
+		Expect(r.GPUFragmentation.Ratio).To(BeNumerically("~", 0.2, 1e-9))
+	})
+
+	It("should compute tenant shares and fairness", func() {
+		Expect(r.Tenants).To(HaveLen(2))
+		teamA, teamB := r.Tenants[0], r.Tenants[1]
+		Expect(teamA.Name).To(Equal("team-a"))
+		Expect(teamA.Jobs).To(Equal(1))
+		Expect(teamA.Pods).To(Equal(2))
+		Expect(teamA.Allocated[report.ResourceGPU]).To(BeNumerically("~", 720, 1e-9))
+		Expect(teamB.Allocated[report.ResourceGPU]).To(BeNumerically("~", 120, 1e-9))
+		Expect(teamB.Share[report.ResourceGPU]).To(BeNumerically("~", 1.0/7, 1e-9))
+		Expect(teamB.JobQueueingDelay.P50).To(BeNumerically("==", 40))
+
+		Expect(r.FairnessResource).To(Equal(report.ResourceGPU))
+		Expect(r.Fairness).To(BeNumerically("~", 840.0*840/(2*(720*720+120*120)), 1e-9))
+	})
+
+	It("should leave system Pods out of jobs and tenants", func() {
+		events, err := timeline.Read(fmt.Sprintf("%s/test/testdata/timeline-system-pods.jsonl", rootProjectDir))
+		Expect(err).NotTo(HaveOccurred(), "failed to read timeline")
+		r = report.Compute(events, time.Time{})
+
+		// System Pods are scheduled and allocated but aren't jobs.
+		Expect(r.Pods.Created).To(Equal(4))
+		Expect(r.Pods.Scheduled).To(Equal(3))
+		Expect(r.Jobs).To(Equal(report.Counts{Created: 2, Scheduled: 2, Succeeded: 2}))
+		Expect(r.JobQueueingDelay.Max).To(BeNumerically("==", 2))
+		Expect(allocation(report.ScopeCluster, "", report.ResourceCPU).Allocated).To(BeNumerically("~", (0.1*11+4*10)/12, 1e-9))
+
+		var tenants []string
+		for _, tenant := range r.Tenants {
+			tenants = append(tenants, tenant.Name)
+		}
+		Expect(tenants).To(Equal([]string{"team-a", "team-b"}))
+		Expect(r.Fairness).To(BeNumerically("~", 1, 1e-9))
+	})
+
+	It("should assign Pods to Jobs and Nodes recorded in the same second", func() {
+		events, err := timeline.Read(fmt.Sprintf("%s/test/testdata/timeline-same-second.jsonl", rootProjectDir))
+		Expect(err).NotTo(HaveOccurred(), "failed to read timeline")
+		r = report.Compute(events, time.Time{})
+
+		// The Job is recorded after its Pods and again once they're scheduled.
+		Expect(r.Jobs).To(Equal(report.Counts{Created: 1, Scheduled: 1, Succeeded: 1}))
+		Expect(r.JobQueueingDelay.Max).To(BeNumerically("==", 5))
+		Expect(r.JobCompletionTime.Max).To(BeNumerically("==", 10))
+
+		Expect(r.Tenants).To(HaveLen(1))
+		Expect(r.Tenants[0].Name).To(Equal("team-a"))
+		Expect(r.Tenants[0].Jobs).To(Equal(1))
+		Expect(r.Tenants[0].Pods).To(Equal(2))
+		Expect(r.Tenants[0].Allocated[report.ResourceGPU]).To(BeNumerically("~", 40, 1e-9))
+		Expect(allocation(report.ScopeCluster, "", report.ResourceGPU).Utilization).To(BeNumerically("~", 0.5, 1e-9))
+	})
+
+	It("should write the report as JSON, CSV, and HTML", func() {
+		dir := GinkgoT().TempDir()
+		Expect(report.Write(r, dir)).To(Succeed())
+
+		data, err := os.ReadFile(filepath.Join(dir, report.JSONFile))
+		Expect(err).NotTo(HaveOccurred())
+		var decoded report.Report
+		Expect(json.Unmarshal(data, &decoded)).To(Succeed())
+		Expect(decoded.GPUFragmentation).To(Equal(r.GPUFragmentation))
+		Expect(decoded.Series.Times).To(HaveLen(200))
+		Expect(decoded.Series.PendingPods[0]).To(BeNumerically("==", 2))
+
+		file, err := os.Open(filepath.Join(dir, report.CSVFile))
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+		rows, err := csv.NewReader(file).ReadAll()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rows[0]).To(Equal([]string{"section", "name", "metric", "value"}))
+		Expect(rows).To(ContainElement([]string{"allocation", "zone:zone-a", "nvidia.com/gpu.utilization", "0.65"}))
+		Expect(rows).To(ContainElement([]string{"gpuFragmentation", "", "ratio", "0.2"}))
+
+		data, err = os.ReadFile(filepath.Join(dir, report.HTMLFile))
+		Expect(err).NotTo(HaveOccurred())
+		html := string(data)
+		Expect(html).To(ContainSubstring("<polyline"))
+		Expect(html).To(ContainSubstring("team-a"))
+		Expect(strings.Contains(html, "<script src=") || strings.Contains(html, "<link ")).To(BeFalse(), "the report should be self-contained")
+	})
+})
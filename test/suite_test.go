@@ -48,7 +48,7 @@ var _ = SynchronizedAfterSuite(func() {
 	//runs on *all* processes, noop
 }, func() {
 	//runs *only* on process #1
-	knownClusters := []string{"it-simple", "it-already-exists", "it-with-kind-config", "it-with-addons", "it-with-kwok-nodes", "it-with-full-config", "e2e-cli-test-cluster"}
+	knownClusters := []string{"it-simple", "it-already-exists", "it-with-kind-config", "it-with-addons", "it-with-kwok-nodes", "it-with-full-config", "it-with-apply", "it-with-scale", "it-with-failure", "it-with-existing", "e2e-cli-test-cluster"}
 	for _, cluster := range knownClusters {
 		err := kind.NewProvider().SetDefaults().WithName(cluster).Destroy(context.Background())
 		Expect(err).NotTo(HaveOccurred(), fmt.Sprintf("failed to destroy cluster %s", cluster))
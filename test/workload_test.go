@@ -0,0 +1,139 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/datastrophic/kemu/pkg/cluster"
+	"github.com/datastrophic/kemu/pkg/workload"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var _ = Describe("workload submission", func() {
+	var options workload.SubmitOptions
+	BeforeEach(func() {
+		options = workload.SubmitOptions{
+			Template: fmt.Sprintf("%s/examples/workloads/job-h100-az-affinity-20-workers.tmpl", rootProjectDir),
+			Count:    5,
+			Arrival:  "fixed:30s",
+			RunID:    "test",
+			Seed:     42,
+		}
+	})
+
+	offsets := func(submissions []workload.Submission) []time.Duration {
+		var out []time.Duration
+		for _, submission := range submissions {
+			out = append(out, submission.Offset)
+		}
+		return out
+	}
+
+	It("should render the template with the job ID and template defaults", func() {
+		submissions, err := workload.Plan(options)
+		Expect(err).NotTo(HaveOccurred(), "failed to plan workloads")
+		Expect(submissions).To(HaveLen(5))
+		Expect(offsets(submissions)).To(Equal([]time.Duration{0, 30 * time.Second, time.Minute, 90 * time.Second, 2 * time.Minute}))
+
+		var job batchv1.Job
+		Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(submissions[3].Object.Object, &job)).To(Succeed())
+		Expect(submissions[3].Params.JobID).To(Equal("job-h100-az-affinity-20-workers-test-3"))
+		Expect(job.Labels).To(HaveKeyWithValue(workload.JobIDLabel, "job-h100-az-affinity-20-workers-test-3"))
+		Expect(*job.Spec.Completions).To(BeEquivalentTo(20))
+		Expect(job.Spec.Template.Labels).To(HaveKeyWithValue("datastrophic.io/workload", "job-h100-az-affinity-20-workers-test-3"))
+		Expect(job.Spec.Template.Labels).To(HaveKeyWithValue(workload.JobIDLabel, "job-h100-az-affinity-20-workers-test-3"))
+		Expect(job.Spec.Template.Annotations).To(HaveKeyWithValue(cluster.PodCompleteDelayAnnotation, "10m"))
+		affinity := job.Spec.Template.Spec.Affinity
+		Expect(affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0].LabelSelector.MatchExpressions[0].Values).
+			To(Equal([]string{"job-h100-az-affinity-20-workers-test-3"}))
+	})
+
+	It("should pass parameters and sampled durations to all Pod templates", func() {
+		options.Template = fmt.Sprintf("%s/test/testdata/workloads/jobset.tmpl", rootProjectDir)
+		options.Workers = 16
+		options.GPUType = "nvidia-h200-141gb"
+		options.Values = map[string]string{"queue": "research"}
+		options.Duration = "uniform:5m,15m"
+		submissions, err := workload.Plan(options)
+		Expect(err).NotTo(HaveOccurred(), "failed to plan workloads")
+
+		for _, submission := range submissions {
+			object := submission.Object
+			Expect(object.GetName()).To(Equal(submission.Params.JobID))
+			Expect(object.GetLabels()).To(HaveKeyWithValue("kueue.x-k8s.io/queue-name", "research"))
+
+			duration, err := time.ParseDuration(submission.Params.Duration)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(duration).To(BeNumerically(">=", 5*time.Minute))
+			Expect(duration).To(BeNumerically("<=", 15*time.Minute))
+
+			replicatedJobs, _, _ := unstructured.NestedSlice(object.Object, "spec", "replicatedJobs")
+			Expect(replicatedJobs).To(HaveLen(2))
+			for _, replicatedJob := range replicatedJobs {
+				annotations, _, _ := unstructured.NestedStringMap(replicatedJob.(map[string]any), "template", "spec", "template", "metadata", "annotations")
+				Expect(annotations).To(HaveKeyWithValue(cluster.PodCompleteDelayAnnotation, submission.Params.Duration))
+			}
+			workers := replicatedJobs[1].(map[string]any)
+			completions, _, _ := unstructured.NestedInt64(workers, "template", "spec", "completions")
+			Expect(completions).To(BeEquivalentTo(16))
+			gpuType, _, _ := unstructured.NestedString(workers, "template", "spec", "template", "spec", "nodeSelector", "datastrophic.io/gpu-type")
+			Expect(gpuType).To(Equal("nvidia-h200-141gb"))
+		}
+	})
+
+	It("should follow the arrival process", func() {
+		options.Arrival = "burst:2/5m"
+		submissions, err := workload.Plan(options)
+		Expect(err).NotTo(HaveOccurred(), "failed to plan workloads")
+		Expect(offsets(submissions)).To(Equal([]time.Duration{0, 0, 5 * time.Minute, 5 * time.Minute, 10 * time.Minute}))
+
+		arrival, err := workload.ParseArrival("poisson:60/m")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(arrival.Interval).To(Equal(time.Second))
+		poisson := arrival.Offsets(10000, rand.New(rand.NewPCG(1, 1)))
+		Expect(poisson[len(poisson)-1]).To(BeNumerically("~", 10000*time.Second, 500*time.Second))
+		Expect(arrival.Offsets(10, rand.New(rand.NewPCG(1, 1)))).To(Equal(poisson[:10]))
+	})
+
+	It("should record planned workloads", func() {
+		options.Duration = "10m"
+		options.DryRun = true
+		var out bytes.Buffer
+		Expect(workload.Submit(options, "", &out)).To(Succeed())
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		Expect(lines).To(HaveLen(5))
+		var record workload.Record
+		Expect(json.Unmarshal([]byte(lines[1]), &record)).To(Succeed())
+		Expect(record).To(Equal(workload.Record{
+			Index:      1,
+			JobID:      "job-h100-az-affinity-20-workers-test-1",
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+			Offset:     "30s",
+			Duration:   "10m0s",
+		}))
+	})
+
+	It("should reject invalid specifications", func() {
+		for _, arrival := range []string{"fixed", "fixed:-1s", "poisson:0/m", "burst:10", "linear:1s"} {
+			options.Arrival = arrival
+			_, err := workload.Plan(options)
+			Expect(err).To(MatchError(ContainSubstring("invalid arrival process")), arrival)
+		}
+		options.Arrival = "fixed:1s"
+		for _, duration := range []string{"uniform:10m", "uniform:15m,5m", "normal:10m,x", "pareto:1m"} {
+			options.Duration = duration
+			_, err := workload.Plan(options)
+			Expect(err).To(MatchError(ContainSubstring("invalid duration distribution")), duration)
+		}
+	})
+})